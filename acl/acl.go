@@ -0,0 +1,88 @@
+package acl
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/realip"
+	"isp-convert-service/service"
+)
+
+var (
+	rules     Rules
+	rulesLock sync.RWMutex
+)
+
+type Rule struct {
+	methods service.MethodMatcher
+	allow   realip.Networks
+	deny    realip.Networks
+}
+
+// Rules are evaluated in order, only the first rule matching the method applies
+type Rules []Rule
+
+func NewRules(list []conf.IpAccessRuleConfig) (Rules, error) {
+	result := make(Rules, 0, len(list))
+	for i, cfg := range list {
+		allow, err := realip.ParseNetworks(cfg.Allow)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rule %d allow list", i)
+		}
+		deny, err := realip.ParseNetworks(cfg.Deny)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rule %d deny list", i)
+		}
+		result = append(result, Rule{
+			methods: service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+			allow:   allow,
+			deny:    deny,
+		})
+	}
+	return result, nil
+}
+
+// Allowed denies addresses from the deny list first, then, if the allow list is not empty,
+// lets through only addresses from it
+func (r Rules) Allowed(method string, ip net.IP) bool {
+	for _, rule := range r {
+		if !rule.methods.Match(method) {
+			continue
+		}
+		if rule.deny.Contains(ip) {
+			return false
+		}
+		return len(rule.allow) == 0 || rule.allow.Contains(ip)
+	}
+	return true
+}
+
+func ReceiveConfiguration(list []conf.IpAccessRuleConfig) error {
+	next, err := NewRules(list)
+	if err != nil {
+		return err
+	}
+	rulesLock.Lock()
+	rules = next
+	rulesLock.Unlock()
+	return nil
+}
+
+// Check evaluates rules against the real client address
+func Check(ctx *fasthttp.RequestCtx, method string) error {
+	rulesLock.RLock()
+	r := rules
+	rulesLock.RUnlock()
+	if len(r) == 0 {
+		return nil
+	}
+	if !r.Allowed(method, realip.FromRequest(ctx).IP) {
+		return status.Errorf(codes.PermissionDenied, "method %s is not available from this address", method)
+	}
+	return nil
+}
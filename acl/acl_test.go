@@ -0,0 +1,46 @@
+package acl
+
+import (
+	"net"
+	"testing"
+
+	"isp-convert-service/conf"
+)
+
+var (
+	ruleConfigs = []conf.IpAccessRuleConfig{
+		{MethodsPatterns: []string{"admin/*/*"}, Allow: []string{"10.10.0.0/16"}, Deny: []string{"10.10.5.0/24"}},
+		{MethodsPatterns: []string{"public/*/*"}, Deny: []string{"203.0.113.7"}},
+		{MethodsPatterns: []string{"admin/*/*", "public/*/*"}, Deny: []string{"0.0.0.0/0"}},
+	}
+	ruleCases = []struct {
+		Method string
+		IP     string
+		Result bool
+	}{
+		{Method: "admin/users/delete", IP: "10.10.1.1", Result: true},
+		{Method: "admin/users/delete", IP: "10.10.5.1", Result: false},
+		{Method: "admin/users/delete", IP: "192.168.1.1", Result: false},
+		{Method: "public/catalog/list", IP: "192.168.1.1", Result: true},
+		{Method: "public/catalog/list", IP: "203.0.113.7", Result: false},
+		{Method: "other/group/method", IP: "203.0.113.7", Result: true},
+	}
+)
+
+func TestRules_Allowed(t *testing.T) {
+	rules, err := NewRules(ruleConfigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range ruleCases {
+		if res := rules.Allowed(c.Method, net.ParseIP(c.IP)); res != c.Result {
+			t.Error(c)
+		}
+	}
+}
+
+func TestNewRules_InvalidNetwork(t *testing.T) {
+	if _, err := NewRules([]conf.IpAccessRuleConfig{{Allow: []string{"10.0.0.0/33"}}}); err == nil {
+		t.Error("expected invalid network to be rejected")
+	}
+}
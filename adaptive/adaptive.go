@@ -0,0 +1,72 @@
+package adaptive
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+)
+
+var (
+	limiter     *Limiter
+	limiterCfg  conf.AdaptiveLimitConfig
+	limiterLock sync.RWMutex
+)
+
+func init() {
+	service.SetRouterResponseTimeObserver(observe)
+}
+
+// ReceiveConfiguration keeps the learned limit if the configuration is not changed
+func ReceiveConfiguration(cfg conf.AdaptiveLimitConfig) error {
+	limiterLock.Lock()
+	defer limiterLock.Unlock()
+
+	if !cfg.Enable {
+		limiter, limiterCfg = nil, cfg
+		return nil
+	}
+	if limiter != nil && limiterCfg == cfg {
+		return nil
+	}
+	min, max, initial := cfg.GetMinLimit(), cfg.GetMaxLimit(), cfg.GetInitialLimit()
+	if min > max || initial < min || initial > max {
+		return errors.Errorf("adaptive limit: expected min %d <= initial %d <= max %d", min, initial, max)
+	}
+	limiter, limiterCfg = NewLimiter(initial, min, max, cfg.GetTolerance()), cfg
+	return nil
+}
+
+func current() *Limiter {
+	limiterLock.RLock()
+	l := limiter
+	limiterLock.RUnlock()
+	return l
+}
+
+// Acquire sheds the call with Unavailable if the router already has as many calls in flight as the limit allows
+func Acquire() (func(), error) {
+	l := current()
+	if l == nil {
+		return func() {}, nil
+	}
+	release, ok := l.Acquire()
+	if !ok {
+		service.GetMetrics().UpdateAdaptiveLimitRejected()
+		return nil, status.Error(codes.Unavailable, "router is overloaded, retry later")
+	}
+	return release, nil
+}
+
+func observe(rtt time.Duration) {
+	l := current()
+	if l == nil {
+		return
+	}
+	l.Observe(rtt)
+	service.GetMetrics().UpdateAdaptiveLimit(l.Limit())
+}
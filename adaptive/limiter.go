@@ -0,0 +1,86 @@
+package adaptive
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	smoothing = 0.2
+	// longRttWindow is the number of samples the baseline latency is averaged over
+	longRttWindow = 600
+)
+
+// Limiter adjusts the limit of in-flight calls by the gradient of the baseline latency to the current one:
+// while latency stays near the baseline the limit grows by a queue of sqrt(limit), when it rises the limit shrinks
+type Limiter struct {
+	inflight int32
+	limit    int32
+
+	lock      sync.Mutex
+	estimate  float64
+	minLimit  float64
+	maxLimit  float64
+	tolerance float64
+	longRtt   float64
+}
+
+func NewLimiter(initial, min, max int, tolerance float64) *Limiter {
+	return &Limiter{
+		limit:     int32(initial),
+		estimate:  float64(initial),
+		minLimit:  float64(min),
+		maxLimit:  float64(max),
+		tolerance: tolerance,
+	}
+}
+
+func (l *Limiter) Acquire() (func(), bool) {
+	if atomic.AddInt32(&l.inflight, 1) > atomic.LoadInt32(&l.limit) {
+		atomic.AddInt32(&l.inflight, -1)
+		return nil, false
+	}
+	return l.release, true
+}
+
+func (l *Limiter) release() {
+	atomic.AddInt32(&l.inflight, -1)
+}
+
+func (l *Limiter) Limit() int {
+	return int(atomic.LoadInt32(&l.limit))
+}
+
+// Observe updates the limit with a latency sample of a call to the router
+func (l *Limiter) Observe(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	shortRtt := float64(rtt)
+	inflight := float64(atomic.LoadInt32(&l.inflight))
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.longRtt == 0 {
+		l.longRtt = shortRtt
+	} else {
+		l.longRtt += (shortRtt - l.longRtt) * 2 / (longRttWindow + 1)
+	}
+	// the baseline follows a long recovered incident faster than the plain average would
+	if l.longRtt/shortRtt > 2 {
+		l.longRtt *= 0.95
+	}
+	// a limit which is not used is not measured, so it is not increased
+	if inflight < l.estimate/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.tolerance*l.longRtt/shortRtt))
+	next := l.estimate*gradient + math.Sqrt(l.estimate)
+	next = l.estimate*(1-smoothing) + next*smoothing
+	l.estimate = math.Max(l.minLimit, math.Min(l.maxLimit, next))
+	atomic.StoreInt32(&l.limit, int32(l.estimate))
+}
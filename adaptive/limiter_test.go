@@ -0,0 +1,51 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Observe(t *testing.T) {
+	l := NewLimiter(10, 2, 100, 1.5)
+	saturate := func() []func() {
+		releases := make([]func(), 0)
+		for {
+			release, ok := l.Acquire()
+			if !ok {
+				return releases
+			}
+			releases = append(releases, release)
+		}
+	}
+
+	releases := saturate()
+	if len(releases) != 10 {
+		t.Fatalf("expected 10 admitted calls, got %d", len(releases))
+	}
+	for i := 0; i < 50; i++ {
+		l.Observe(10 * time.Millisecond)
+	}
+	grown := l.Limit()
+	if grown <= 10 {
+		t.Fatalf("limit must grow while latency is stable, got %d", grown)
+	}
+
+	releases = append(releases, saturate()...)
+	for i := 0; i < 50; i++ {
+		l.Observe(200 * time.Millisecond)
+	}
+	if l.Limit() >= grown {
+		t.Fatalf("limit must shrink when latency rises, got %d", l.Limit())
+	}
+	for _, release := range releases {
+		release()
+	}
+
+	idle := l.Limit()
+	for i := 0; i < 50; i++ {
+		l.Observe(10 * time.Millisecond)
+	}
+	if l.Limit() != idle {
+		t.Errorf("limit must not change without load, got %d", l.Limit())
+	}
+}
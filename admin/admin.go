@@ -0,0 +1,153 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	log "github.com/integration-system/isp-log"
+	"github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"isp-convert-service/conf"
+	"isp-convert-service/log_code"
+)
+
+const (
+	JsonContentType = "application/json; charset=utf-8"
+
+	bearerPrefix = "Bearer "
+	// maskedKeyLength is the number of hex digits of the hash shown instead of a key
+	maskedKeyLength = 16
+)
+
+var (
+	json = jsoniter.ConfigFastest
+
+	routes     []route
+	routesLock sync.Mutex
+
+	srv     *fasthttp.Server
+	address string
+	srvLock sync.Mutex
+
+	token     string
+	tokenLock sync.RWMutex
+)
+
+type route struct {
+	method  string
+	path    string
+	handler fasthttp.RequestHandler
+}
+
+// Handle registers an endpoint of the admin listener, endpoints registered after the listener is started
+// become available on the next restart, so packages register them in init
+func Handle(method, path string, handler fasthttp.RequestHandler) {
+	routesLock.Lock()
+	routes = append(routes, route{method: method, path: path, handler: handler})
+	routesLock.Unlock()
+}
+
+// ReceiveConfiguration restarts the admin listener only if the address is changed, the token is swapped in place.
+// Admin endpoints expose usage of applications, so the listener is never started without a token
+func ReceiveConfiguration(cfg conf.AdminConfig) error {
+	if cfg.Enable && cfg.Token == "" {
+		return errors.New("admin: token is not specified")
+	}
+	tokenLock.Lock()
+	token = cfg.Token
+	tokenLock.Unlock()
+
+	next := ""
+	if cfg.Enable {
+		next = cfg.Address.GetAddress()
+	}
+
+	srvLock.Lock()
+	defer srvLock.Unlock()
+	if srv != nil && address == next {
+		return nil
+	}
+	shutdown()
+	address = next
+	if next == "" {
+		return nil
+	}
+
+	srv = &fasthttp.Server{
+		Handler:      newRouter().Handler,
+		WriteTimeout: time.Second * 60,
+		ReadTimeout:  time.Second * 60,
+	}
+	go func(srv *fasthttp.Server, addr string) {
+		if err := srv.ListenAndServe(addr); err != nil {
+			log.Errorf(log_code.ErrorAdminServer, "admin server on %s: %v", addr, err)
+		}
+	}(srv, next)
+	return nil
+}
+
+func Shutdown() {
+	srvLock.Lock()
+	shutdown()
+	srvLock.Unlock()
+}
+
+func shutdown() {
+	if srv == nil {
+		return
+	}
+	if err := srv.Shutdown(); err != nil {
+		log.Warnf(log_code.ErrorAdminServer, "admin server shutdown: %v", err)
+	}
+	srv = nil
+}
+
+func newRouter() *fasthttprouter.Router {
+	router := fasthttprouter.New()
+	routesLock.Lock()
+	for _, r := range routes {
+		router.Handle(r.method, r.path, authorize(r.handler))
+	}
+	routesLock.Unlock()
+	return router
+}
+
+func authorize(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		tokenLock.RLock()
+		expected := token
+		tokenLock.RUnlock()
+
+		value := string(ctx.Request.Header.Peek("Authorization"))
+		if expected == "" || len(value) <= len(bearerPrefix) || value[:len(bearerPrefix)] != bearerPrefix ||
+			subtle.ConstantTimeCompare([]byte(value[len(bearerPrefix):]), []byte(expected)) != 1 {
+			SendJson(ctx, http.StatusUnauthorized, map[string]string{"error": "admin token required"})
+			return
+		}
+		handler(ctx)
+	}
+}
+
+// MaskKey replaces a client credential, such as an application token, with the prefix of its hash.
+// Operators find a known key by the hash of it, while responses never reveal the keys
+func MaskKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])[:maskedKeyLength]
+}
+
+func SendJson(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetContentType(JsonContentType)
+	_, _ = ctx.Write(data)
+}
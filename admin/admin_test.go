@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"isp-convert-service/conf"
+)
+
+func TestAuthorize(t *testing.T) {
+	handler := authorize(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(http.StatusOK)
+	})
+	cases := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{token: "", authorization: "", status: http.StatusUnauthorized},
+		{token: "", authorization: "Bearer ", status: http.StatusUnauthorized},
+		{token: "secret", authorization: "", status: http.StatusUnauthorized},
+		{token: "secret", authorization: "Bearer other", status: http.StatusUnauthorized},
+		{token: "secret", authorization: "Bearer secret", status: http.StatusOK},
+	}
+	for _, c := range cases {
+		tokenLock.Lock()
+		token = c.token
+		tokenLock.Unlock()
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.Set("Authorization", c.authorization)
+		handler(ctx)
+		if ctx.Response.StatusCode() != c.status {
+			t.Errorf("token '%s', authorization '%s': expected status %d, got %d",
+				c.token, c.authorization, c.status, ctx.Response.StatusCode())
+		}
+	}
+}
+
+func TestReceiveConfiguration_TokenRequired(t *testing.T) {
+	if err := ReceiveConfiguration(conf.AdminConfig{Enable: true}); err == nil {
+		t.Error("admin listener must not be enabled without a token")
+	}
+}
+
+func TestMaskKey(t *testing.T) {
+	masked := MaskKey("application-token")
+	if strings.Contains(masked, "application-token") || masked != MaskKey("application-token") || masked == MaskKey("other") {
+		t.Errorf("unexpected masked key %s", masked)
+	}
+}
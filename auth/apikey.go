@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+	"isp-convert-service/utils"
+)
+
+const (
+	ApiKeyOwnerHeader = "x-api-key-owner"
+)
+
+var (
+	apiKeys     *apiKeyRegistry
+	apiKeysLock sync.RWMutex
+)
+
+type apiKey struct {
+	owner     string
+	methods   service.MethodMatcher
+	expiresAt time.Time
+	enabled   bool
+}
+
+type apiKeyRegistry struct {
+	header  string
+	methods service.MethodMatcher
+	all     bool
+	keys    map[string]*apiKey
+}
+
+// loadApiKeys merges keys from remote config with keys from the local file, remote config wins on conflicts
+func loadApiKeys(cfg conf.ApiKeysConfig) (map[string]*apiKey, error) {
+	list := make([]conf.ApiKeyConfig, 0, len(cfg.Keys))
+	if cfg.File != "" {
+		data, err := ioutil.ReadFile(cfg.File)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read api keys file %s", cfg.File)
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal api keys file %s", cfg.File)
+		}
+	}
+	list = append(list, cfg.Keys...)
+
+	keys := make(map[string]*apiKey, len(list))
+	for _, k := range list {
+		if k.Key == "" {
+			return nil, errors.Errorf("api key of '%s' is empty", k.Owner)
+		}
+		key := &apiKey{
+			owner:   k.Owner,
+			methods: service.NewCacheableMethodMatcher(k.AllowedMethodsPatterns),
+			enabled: k.Enabled,
+		}
+		if k.ExpiresAt != "" {
+			expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "expiration time of api key of '%s'", k.Owner)
+			}
+			key.expiresAt = expiresAt
+		}
+		keys[k.Key] = key
+	}
+	return keys, nil
+}
+
+func ReceiveApiKeysConfiguration(cfg conf.ApiKeysConfig) error {
+	var next *apiKeyRegistry
+	if cfg.Enable {
+		keys, err := loadApiKeys(cfg)
+		if err != nil {
+			return err
+		}
+		next = &apiKeyRegistry{
+			header:  cfg.GetHeader(),
+			methods: service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+			all:     len(cfg.MethodsPatterns) == 0,
+			keys:    keys,
+		}
+		// the key is consumed by the converter and must not leak to backends
+		utils.ReserveMetadataKeys("api-key", ApiKeyOwnerHeader, strings.ToLower(next.header))
+	} else {
+		utils.ReserveMetadataKeys("api-key", ApiKeyOwnerHeader)
+	}
+
+	apiKeysLock.Lock()
+	apiKeys = next
+	apiKeysLock.Unlock()
+	return nil
+}
+
+// VerifyApiKey checks that the key from the request exists, is active and allows the called method
+func VerifyApiKey(ctx *fasthttp.RequestCtx, method string) error {
+	apiKeysLock.RLock()
+	r := apiKeys
+	apiKeysLock.RUnlock()
+	if r == nil || (!r.all && !r.methods.Match(method)) {
+		return nil
+	}
+
+	value := string(ctx.Request.Header.Peek(r.header))
+	if value == "" {
+		return status.Error(codes.Unauthenticated, "api key required")
+	}
+	key, ok := r.keys[value]
+	if !ok || !key.enabled || (!key.expiresAt.IsZero() && time.Now().After(key.expiresAt)) {
+		return status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	if !key.methods.Match(method) {
+		return status.Errorf(codes.PermissionDenied, "api key is not allowed to call method %s", method)
+	}
+	utils.SetRequestMetadata(ctx, ApiKeyOwnerHeader, asciiSafe(key.owner))
+	return nil
+}
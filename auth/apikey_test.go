@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/utils"
+)
+
+func apiKeyRequest(uri, key string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(uri)
+	if key != "" {
+		ctx.Request.Header.Set("X-Api-Key", key)
+	}
+	return ctx
+}
+
+func TestVerifyApiKey(t *testing.T) {
+	err := ReceiveApiKeysConfiguration(conf.ApiKeysConfig{
+		Enable:          true,
+		MethodsPatterns: []string{"billing/*/*"},
+		Keys: []conf.ApiKeyConfig{
+			{Key: "valid", Owner: "partner", AllowedMethodsPatterns: []string{"billing/invoice/*"}, Enabled: true},
+			{Key: "disabled", Owner: "former", AllowedMethodsPatterns: []string{"*/*/*"}},
+			{
+				Key:                    "expired",
+				Owner:                  "trial",
+				AllowedMethodsPatterns: []string{"*/*/*"},
+				Enabled:                true,
+				ExpiresAt:              time.Now().Add(-time.Hour).Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ReceiveApiKeysConfiguration(conf.ApiKeysConfig{})
+	}()
+
+	cases := []struct {
+		name   string
+		method string
+		key    string
+		code   codes.Code
+	}{
+		{name: "valid key", method: "billing/invoice/get", key: "valid", code: codes.OK},
+		{name: "method out of key scope", method: "billing/account/get", key: "valid", code: codes.PermissionDenied},
+		{name: "missing key", method: "billing/invoice/get", code: codes.Unauthenticated},
+		{name: "unknown key", method: "billing/invoice/get", key: "unknown", code: codes.Unauthenticated},
+		{name: "disabled key", method: "billing/invoice/get", key: "disabled", code: codes.Unauthenticated},
+		{name: "expired key", method: "billing/invoice/get", key: "expired", code: codes.Unauthenticated},
+		{name: "method without key requirement", method: "catalog/item/get", code: codes.OK},
+	}
+	for _, c := range cases {
+		err := VerifyApiKey(apiKeyRequest("/api/"+c.method, c.key), c.method)
+		if code := status.Code(err); code != c.code {
+			t.Errorf("%s: expected %s, got %s", c.name, c.code, code)
+		}
+	}
+}
+
+func TestVerifyApiKey_MetadataStripped(t *testing.T) {
+	err := ReceiveApiKeysConfiguration(conf.ApiKeysConfig{
+		Enable: true,
+		Keys:   []conf.ApiKeyConfig{{Key: "valid", Owner: "partner", AllowedMethodsPatterns: []string{"*/*/*"}, Enabled: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ReceiveApiKeysConfiguration(conf.ApiKeysConfig{})
+	}()
+
+	ctx := apiKeyRequest("/api/module/group/method", "valid")
+	ctx.Request.Header.Set("X-Api-Key-Owner", "forged")
+	ctx.Request.Header.Set("X-Tenant-Id", "42")
+	if err := VerifyApiKey(ctx, "module/group/method"); err != nil {
+		t.Fatal(err)
+	}
+	md, _ := utils.MakeMetadata(ctx, "/api/module/group/method")
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		t.Errorf("api key is not expected in metadata, got %v", values)
+	}
+	if values := md.Get(ApiKeyOwnerHeader); len(values) != 1 || values[0] != "partner" {
+		t.Errorf("expected verified owner in metadata, got %v", values)
+	}
+	if values := md.Get("x-tenant-id"); len(values) != 1 || values[0] != "42" {
+		t.Errorf("other headers are expected to be forwarded, got %v", values)
+	}
+}
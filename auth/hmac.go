@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+	"isp-convert-service/utils"
+)
+
+const (
+	HmacKeyIdHeader = "x-hmac-key-id"
+
+	headerSignatureKeyId     = "X-Signature-Key-Id"
+	headerSignature          = "X-Signature"
+	headerSignatureTimestamp = "X-Signature-Timestamp"
+	headerSignatureNonce     = "X-Signature-Nonce"
+
+	maxNonceLength = 128
+)
+
+var (
+	hmacAuth     *hmacAuthenticator
+	hmacAuthLock sync.RWMutex
+	// hmacNonces outlives configurations, so that requests can't be replayed after a reload
+	hmacNonces = NewNonceCache()
+)
+
+func init() {
+	utils.ReserveMetadataKeys("hmac", HmacKeyIdHeader)
+}
+
+type SignedRequest struct {
+	KeyId     string
+	Signature string
+	Method    string
+	Uri       string
+	Timestamp string
+	Nonce     string
+	Body      []byte
+}
+
+// StringToSign joins request attributes by new lines: http method, request uri with query,
+// unix timestamp in seconds, nonce and hex encoded sha256 of the body
+func (r SignedRequest) StringToSign() string {
+	bodyHash := sha256.Sum256(r.Body)
+	return strings.Join([]string{
+		strings.ToUpper(r.Method),
+		r.Uri,
+		r.Timestamp,
+		r.Nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+type HmacVerifier struct {
+	secrets  map[string][]byte
+	hashFunc func() hash.Hash
+	skew     time.Duration
+	nonces   *NonceCache
+	now      func() time.Time
+}
+
+func NewHmacVerifier(secrets map[string]string, algorithm string, skew time.Duration, nonces *NonceCache) (*HmacVerifier, error) {
+	v := &HmacVerifier{
+		secrets: make(map[string][]byte, len(secrets)),
+		skew:    skew,
+		nonces:  nonces,
+		now:     time.Now,
+	}
+	switch strings.ToLower(algorithm) {
+	case "", "sha256":
+		v.hashFunc = sha256.New
+	case "sha512":
+		v.hashFunc = sha512.New
+	default:
+		return nil, errors.Errorf("unsupported hmac algorithm '%s'", algorithm)
+	}
+	for keyId, secret := range secrets {
+		v.secrets[keyId] = []byte(secret)
+	}
+	return v, nil
+}
+
+func (v *HmacVerifier) Verify(r SignedRequest) error {
+	secret, ok := v.secrets[r.KeyId]
+	if !ok {
+		return errors.Errorf("unknown key id '%s'", r.KeyId)
+	}
+	ts, err := strconv.ParseInt(r.Timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	now := v.now()
+	signedAt := time.Unix(ts, 0)
+	if signedAt.Before(now.Add(-v.skew)) || signedAt.After(now.Add(v.skew)) {
+		return errors.New("timestamp is outside of the allowed window")
+	}
+	if r.Nonce == "" || len(r.Nonce) > maxNonceLength {
+		return errors.New("invalid nonce")
+	}
+	signature, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+
+	mac := hmac.New(v.hashFunc, secret)
+	_, _ = mac.Write([]byte(r.StringToSign()))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errors.New("invalid signature")
+	}
+	// the nonce is remembered only for valid signatures, otherwise anyone could burn nonces of a partner
+	if !v.nonces.Add(r.KeyId+":"+r.Nonce, now.Add(2*v.skew), now) {
+		return errors.New("request is replayed")
+	}
+	return nil
+}
+
+// NonceCache remembers used nonces until their timestamps leave the allowed window
+type NonceCache struct {
+	lock        sync.Mutex
+	entries     map[string]time.Time
+	lastCleanup time.Time
+}
+
+func NewNonceCache() *NonceCache {
+	return &NonceCache{entries: make(map[string]time.Time)}
+}
+
+// Add reports false if the nonce is already used
+func (c *NonceCache) Add(nonce string, expireAt time.Time, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now.Sub(c.lastCleanup) > time.Minute {
+		for key, exp := range c.entries {
+			if now.After(exp) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastCleanup = now
+	}
+
+	if exp, ok := c.entries[nonce]; ok && !now.After(exp) {
+		return false
+	}
+	c.entries[nonce] = expireAt
+	return true
+}
+
+type hmacAuthenticator struct {
+	methods  service.MethodMatcher
+	verifier *HmacVerifier
+}
+
+func ReceiveHmacConfiguration(cfg conf.HmacConfig) error {
+	var next *hmacAuthenticator
+	if cfg.Enable {
+		verifier, err := NewHmacVerifier(cfg.Keys, cfg.Algorithm, cfg.GetClockSkew(), hmacNonces)
+		if err != nil {
+			return err
+		}
+		next = &hmacAuthenticator{
+			methods:  service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+			verifier: verifier,
+		}
+	}
+	hmacAuthLock.Lock()
+	hmacAuth = next
+	hmacAuthLock.Unlock()
+	return nil
+}
+
+// VerifyHmacSignature checks signatures of requests to configured methods and passes the verified key id to backends
+func VerifyHmacSignature(ctx *fasthttp.RequestCtx, method string) error {
+	hmacAuthLock.RLock()
+	a := hmacAuth
+	hmacAuthLock.RUnlock()
+	if a == nil || !a.methods.Match(method) {
+		return nil
+	}
+
+	header := &ctx.Request.Header
+	r := SignedRequest{
+		KeyId:     string(header.Peek(headerSignatureKeyId)),
+		Signature: strings.ToLower(string(header.Peek(headerSignature))),
+		Method:    string(ctx.Method()),
+		Uri:       string(ctx.RequestURI()),
+		Timestamp: string(header.Peek(headerSignatureTimestamp)),
+		Nonce:     string(header.Peek(headerSignatureNonce)),
+		Body:      ctx.Request.Body(),
+	}
+	if r.KeyId == "" || r.Signature == "" {
+		return status.Error(codes.Unauthenticated, "request signature required")
+	}
+	if err := a.verifier.Verify(r); err != nil {
+		return status.Error(codes.Unauthenticated, "invalid request signature: "+err.Error())
+	}
+	utils.SetRequestMetadata(ctx, HmacKeyIdHeader, asciiSafe(r.KeyId))
+	return nil
+}
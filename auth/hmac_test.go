@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"isp-convert-service/conf"
+)
+
+func sign(secret string, r SignedRequest) SignedRequest {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(r.StringToSign()))
+	r.Signature = hex.EncodeToString(mac.Sum(nil))
+	return r
+}
+
+func TestHmacVerifier_Verify(t *testing.T) {
+	verifier, err := NewHmacVerifier(map[string]string{"partner": "secret"}, "sha256", time.Minute, NewNonceCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	request := func(nonce string, ts time.Time) SignedRequest {
+		return SignedRequest{
+			KeyId:     "partner",
+			Method:    "POST",
+			Uri:       "/api/module/group/method",
+			Timestamp: strconv.FormatInt(ts.Unix(), 10),
+			Nonce:     nonce,
+			Body:      []byte(`{"id":1}`),
+		}
+	}
+
+	valid := sign("secret", request("n1", now))
+	if err := verifier.Verify(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := verifier.Verify(valid); err == nil {
+		t.Error("expected replayed request to be rejected")
+	}
+
+	tampered := sign("secret", request("n2", now))
+	tampered.Body = []byte(`{"id":2}`)
+	if err := verifier.Verify(tampered); err == nil {
+		t.Error("expected tampered body to be rejected")
+	}
+	if err := verifier.Verify(sign("secret", request("n2", now))); err != nil {
+		t.Errorf("nonce of a rejected request must stay usable: %v", err)
+	}
+
+	if err := verifier.Verify(sign("other", request("n3", now))); err == nil {
+		t.Error("expected wrong secret to be rejected")
+	}
+	if err := verifier.Verify(sign("secret", request("n4", now.Add(-2*time.Minute)))); err == nil {
+		t.Error("expected old timestamp to be rejected")
+	}
+	if err := verifier.Verify(sign("secret", request("", now))); err == nil {
+		t.Error("expected empty nonce to be rejected")
+	}
+	unknown := sign("secret", request("n5", now))
+	unknown.KeyId = "unknown"
+	if err := verifier.Verify(unknown); err == nil {
+		t.Error("expected unknown key to be rejected")
+	}
+}
+
+func TestReceiveHmacConfiguration_KeepsNonces(t *testing.T) {
+	cfg := conf.HmacConfig{Enable: true, Keys: map[string]string{"partner": "secret"}, MethodsPatterns: []string{"*"}}
+	if err := ReceiveHmacConfiguration(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ReceiveHmacConfiguration(conf.HmacConfig{})
+	}()
+	request := sign("secret", SignedRequest{
+		KeyId:     "partner",
+		Method:    "POST",
+		Uri:       "/api/module/group/method",
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     "reloaded",
+	})
+	if err := hmacAuth.verifier.Verify(request); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Keys = map[string]string{"partner": "secret", "other": "secret2"}
+	if err := ReceiveHmacConfiguration(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := hmacAuth.verifier.Verify(request); err == nil {
+		t.Fatal("expected request replayed after reload to be rejected")
+	}
+}
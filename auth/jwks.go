@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/integration-system/isp-log"
+	"github.com/pkg/errors"
+	"isp-convert-service/log_code"
+)
+
+const (
+	jwksFetchTimeout     = 10 * time.Second
+	jwksMinRefreshPeriod = 10 * time.Second
+	maxJwksSize          = 1 << 20
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwksFlight is a running load of keys, done is closed when err is set
+type jwksFlight struct {
+	done chan struct{}
+	err  error
+}
+
+// KeySet holds public keys from a JWKS document, which is loaded from a file or an url
+// and refreshed periodically or when a token refers to an unknown key
+type KeySet struct {
+	file   string
+	url    string
+	client *http.Client
+
+	lock        sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	refreshLock sync.Mutex
+	// lastAttempt is the time of the last load, successful or not, and flight is the running load,
+	// both are guarded by refreshLock, the load itself runs without it
+	lastAttempt time.Time
+	flight      *jwksFlight
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewKeySet(file, url string) *KeySet {
+	return &KeySet{
+		file:   file,
+		url:    url,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		keys:   make(map[string]crypto.PublicKey),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start loads keys and then refreshes them with the given period until Stop is called
+func (ks *KeySet) Start(period time.Duration) {
+	if err := ks.Refresh(); err != nil {
+		log.Warnf(log_code.WarnJwksRefresh, "could not load jwks: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ks.Refresh(); err != nil {
+					log.Warnf(log_code.WarnJwksRefresh, "could not refresh jwks, previous keys stay in use: %v", err)
+				}
+			case <-ks.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (ks *KeySet) Stop() {
+	ks.stopOnce.Do(func() {
+		close(ks.stop)
+	})
+}
+
+func (ks *KeySet) Refresh() error {
+	flight, _ := ks.startRefresh(true)
+	<-flight.done
+	return flight.err
+}
+
+// startRefresh joins the running load or starts a new one, unless it isn't forced and the last attempt
+// was less than jwksMinRefreshPeriod ago, then nil is returned. started reports if the load was started by this call
+func (ks *KeySet) startRefresh(force bool) (flight *jwksFlight, started bool) {
+	ks.refreshLock.Lock()
+	if ks.flight != nil {
+		flight = ks.flight
+		ks.refreshLock.Unlock()
+		return flight, false
+	}
+	if !force && time.Since(ks.lastAttempt) < jwksMinRefreshPeriod {
+		ks.refreshLock.Unlock()
+		return nil, false
+	}
+	flight = &jwksFlight{done: make(chan struct{})}
+	ks.flight = flight
+	ks.lastAttempt = time.Now()
+	ks.refreshLock.Unlock()
+
+	flight.err = ks.refresh()
+
+	ks.refreshLock.Lock()
+	ks.flight = nil
+	ks.refreshLock.Unlock()
+	close(flight.done)
+	return flight, true
+}
+
+func (ks *KeySet) refresh() error {
+	data, err := ks.load()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJwks(data)
+	if err != nil {
+		return err
+	}
+
+	ks.lock.Lock()
+	ks.keys = keys
+	ks.lock.Unlock()
+	return nil
+}
+
+// Key looks up a key by id. An unknown id triggers a refresh, because keys could have been rotated,
+// but not more often than jwksMinRefreshPeriod, failed loads included, to withstand tokens with random ids
+// and an unavailable JWKS endpoint. Requests with unknown ids wait for a running refresh instead of repeating it,
+// lookups of known ids are not blocked by it
+func (ks *KeySet) Key(kid string) (crypto.PublicKey, bool) {
+	ks.lock.RLock()
+	key, ok := ks.lookup(kid)
+	ks.lock.RUnlock()
+	if ok {
+		return key, ok
+	}
+
+	flight, started := ks.startRefresh(false)
+	if flight == nil {
+		return nil, false
+	}
+	<-flight.done
+	if started && flight.err != nil {
+		log.Warnf(log_code.WarnJwksRefresh, "could not refresh jwks: %v", flight.err)
+	}
+
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+	return ks.lookup(kid)
+}
+
+func (ks *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := ks.keys[kid]; ok {
+		return key, true
+	}
+	// tokens without kid are accepted only if there is exactly one key
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (ks *KeySet) load() ([]byte, error) {
+	if ks.file != "" {
+		data, err := ioutil.ReadFile(ks.file)
+		return data, errors.Wrapf(err, "read jwks file %s", ks.file)
+	}
+	if ks.url == "" {
+		return nil, errors.New("neither jwks file nor url is configured")
+	}
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch jwks %s", ks.url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetch jwks %s: unexpected status %d", ks.url, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxJwksSize))
+	return data, errors.Wrapf(err, "read jwks %s", ks.url)
+}
+
+func parseJwks(data []byte) (map[string]crypto.PublicKey, error) {
+	set := jwkSet{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "unmarshal jwks")
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "key '%s'", k.Kid)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks contains no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		// symmetric and unknown keys are not used for bearer tokens from an identity provider
+		return nil, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
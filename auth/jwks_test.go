@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeySet_KeyRefreshRateLimit(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, _ := json.Marshal(jwkSet{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: "rotated",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+
+	var (
+		hits      int32
+		available int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&available) == 0 {
+			time.Sleep(20 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(document)
+	}))
+	defer server.Close()
+
+	ks := NewKeySet("", server.URL)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := ks.Key("rotated"); ok {
+				t.Error("key is not expected while the endpoint is down")
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("expected one fetch for concurrent lookups of unknown key, got %d", hits)
+	}
+
+	atomic.StoreInt32(&available, 1)
+	if _, ok := ks.Key("rotated"); ok || atomic.LoadInt32(&hits) != 1 {
+		t.Fatal("failed fetch is expected to rate limit the next one")
+	}
+
+	ks.refreshLock.Lock()
+	ks.lastAttempt = time.Now().Add(-jwksMinRefreshPeriod)
+	ks.refreshLock.Unlock()
+	if _, ok := ks.Key("rotated"); !ok {
+		t.Fatal("expected key after refresh")
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("expected second fetch after the refresh period, got %d", hits)
+	}
+}
+
+func TestKeySet_KnownKeyDuringRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, _ := json.Marshal(jwkSet{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: "known",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+
+	var (
+		hits int32
+		slow int32
+	)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&slow) == 1 {
+			<-release
+		}
+		_, _ = w.Write(document)
+	}))
+	defer server.Close()
+
+	ks := NewKeySet("", server.URL)
+	if err := ks.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&slow, 1)
+	ks.refreshLock.Lock()
+	ks.lastAttempt = time.Now().Add(-jwksMinRefreshPeriod)
+	ks.refreshLock.Unlock()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ks.Key("unknown")
+		}()
+	}
+	for atomic.LoadInt32(&hits) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	found := make(chan bool)
+	go func() {
+		_, ok := ks.Key("known")
+		found <- ok
+	}()
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Error("expected known key")
+		}
+	case <-time.After(time.Second):
+		t.Error("lookup of known key is blocked by the refresh")
+	}
+
+	close(release)
+	wg.Wait()
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("expected one fetch for concurrent lookups of unknown key, got %d", hits)
+	}
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+	"isp-convert-service/utils"
+)
+
+const (
+	bearerPrefix = "bearer "
+)
+
+var (
+	jwtAuth     *jwtAuthenticator
+	jwtAuthLock sync.RWMutex
+
+	signingAlgorithms = map[string]struct {
+		hash crypto.Hash
+		pss  bool
+	}{
+		"RS256": {hash: crypto.SHA256},
+		"RS384": {hash: crypto.SHA384},
+		"RS512": {hash: crypto.SHA512},
+		"PS256": {hash: crypto.SHA256, pss: true},
+		"PS384": {hash: crypto.SHA384, pss: true},
+		"PS512": {hash: crypto.SHA512, pss: true},
+		"ES256": {hash: crypto.SHA256},
+		"ES384": {hash: crypto.SHA384},
+		"ES512": {hash: crypto.SHA512},
+	}
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type Claims map[string]interface{}
+
+type keyProvider interface {
+	Key(kid string) (crypto.PublicKey, bool)
+}
+
+// JwtVerifier checks the signature and the registered claims of a compact serialized token
+type JwtVerifier struct {
+	keys      keyProvider
+	issuers   map[string]bool
+	audiences map[string]bool
+	leeway    time.Duration
+	now       func() time.Time
+}
+
+func NewJwtVerifier(keys keyProvider, issuers, audiences []string, leeway time.Duration) *JwtVerifier {
+	v := &JwtVerifier{
+		keys:      keys,
+		issuers:   make(map[string]bool, len(issuers)),
+		audiences: make(map[string]bool, len(audiences)),
+		leeway:    leeway,
+		now:       time.Now,
+	}
+	for _, iss := range issuers {
+		v.issuers[iss] = true
+	}
+	for _, aud := range audiences {
+		v.audiences[aud] = true
+	}
+	return v
+}
+
+func (v *JwtVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Wrap(err, "token header")
+	}
+	alg, ok := signingAlgorithms[header.Alg]
+	if !ok {
+		return nil, errors.Errorf("unsupported signing algorithm '%s'", header.Alg)
+	}
+	key, ok := v.keys.Key(header.Kid)
+	if !ok {
+		return nil, errors.Errorf("unknown signing key '%s'", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "token signature")
+	}
+	h := alg.hash.New()
+	_, _ = h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(key, header.Alg, alg.hash, alg.pss, h.Sum(nil), signature); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Wrap(err, "token claims")
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JwtVerifier) validateClaims(claims Claims) error {
+	now := v.now()
+	exp, ok := claims.time("exp")
+	if !ok {
+		return errors.New("token has no expiration time")
+	}
+	if now.After(exp.Add(v.leeway)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	if len(v.issuers) > 0 {
+		if iss, _ := claims["iss"].(string); !v.issuers[iss] {
+			return errors.Errorf("unexpected issuer '%s'", iss)
+		}
+	}
+	if len(v.audiences) > 0 {
+		matched := false
+		for _, aud := range claims.strings("aud") {
+			if v.audiences[aud] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return errors.New("token is not issued for this audience")
+		}
+	}
+	return nil
+}
+
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, pss bool, digest, signature []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' && alg[0] != 'P' {
+			break
+		}
+		var err error
+		if pss {
+			err = rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		} else {
+			err = rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		}
+		if err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	return errors.Errorf("signing algorithm '%s' does not match the key type", alg)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func (c Claims) strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// Value formats a claim for metadata: scalars as is, arrays as comma separated values, objects as json
+func (c Claims) Value(name string) (string, bool) {
+	switch v := c[name].(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return strings.Join(values, ","), true
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err == nil
+	}
+}
+
+type jwtAuthenticator struct {
+	header   string
+	methods  service.MethodMatcher
+	all      bool
+	keySet   *KeySet
+	verifier *JwtVerifier
+	claims   map[string]string
+}
+
+func ReceiveJwtConfiguration(cfg conf.JwtConfig) {
+	var next *jwtAuthenticator
+	claimKeys := make([]string, 0, len(cfg.ClaimsMetadata))
+	if cfg.Enable {
+		keySet := NewKeySet(cfg.JwksFile, cfg.JwksUrl)
+		keySet.Start(cfg.GetJwksRefreshPeriod())
+		next = &jwtAuthenticator{
+			header:   cfg.GetHeader(),
+			methods:  service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+			all:      len(cfg.MethodsPatterns) == 0,
+			keySet:   keySet,
+			verifier: NewJwtVerifier(keySet, cfg.Issuers, cfg.Audiences, cfg.GetClockSkew()),
+			claims:   make(map[string]string, len(cfg.ClaimsMetadata)),
+		}
+		for claim, key := range cfg.ClaimsMetadata {
+			key = strings.ToLower(key)
+			next.claims[claim] = key
+			claimKeys = append(claimKeys, key)
+		}
+	}
+	utils.ReserveMetadataKeys("jwt", claimKeys...)
+
+	jwtAuthLock.Lock()
+	prev := jwtAuth
+	jwtAuth = next
+	jwtAuthLock.Unlock()
+	if prev != nil {
+		prev.keySet.Stop()
+	}
+}
+
+// VerifyJwt validates the bearer token of the request and maps configured claims to metadata
+func VerifyJwt(ctx *fasthttp.RequestCtx, method string) error {
+	jwtAuthLock.RLock()
+	a := jwtAuth
+	jwtAuthLock.RUnlock()
+	if a == nil || (!a.all && !a.methods.Match(method)) {
+		return nil
+	}
+
+	value := strings.TrimSpace(string(ctx.Request.Header.Peek(a.header)))
+	if len(value) > len(bearerPrefix) && strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+		value = strings.TrimSpace(value[len(bearerPrefix):])
+	}
+	if value == "" {
+		return status.Error(codes.Unauthenticated, "bearer token required")
+	}
+	claims, err := a.verifier.Verify(value)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "invalid bearer token: "+err.Error())
+	}
+	for claim, key := range a.claims {
+		if v, ok := claims.Value(claim); ok {
+			utils.SetRequestMetadata(ctx, key, asciiSafe(v))
+		}
+	}
+	return nil
+}
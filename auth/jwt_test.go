@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type staticKeys map[string]crypto.PublicKey
+
+func (k staticKeys) Key(kid string) (crypto.PublicKey, bool) {
+	key, ok := k[kid]
+	return key, ok
+}
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJwtVerifier_Verify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := staticKeys{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	verifier := NewJwtVerifier(keys, []string{"https://idp"}, []string{"converter"}, time.Minute)
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"iss": "https://idp", "aud": []string{"other", "converter"}, "exp": now + 60, "sub": "42"}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := make(map[string]interface{}, len(valid))
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+
+	cases := []struct {
+		Name  string
+		Token string
+		Valid bool
+	}{
+		{Name: "rs256", Token: signToken(t, "RS256", "rsa", rsaKey, valid), Valid: true},
+		{Name: "es256", Token: signToken(t, "ES256", "ec", ecKey, valid), Valid: true},
+		{Name: "expired", Token: signToken(t, "RS256", "rsa", rsaKey, with("exp", now-120))},
+		{Name: "expired within leeway", Token: signToken(t, "RS256", "rsa", rsaKey, with("exp", now-30)), Valid: true},
+		{Name: "not before", Token: signToken(t, "RS256", "rsa", rsaKey, with("nbf", now+120))},
+		{Name: "issuer", Token: signToken(t, "RS256", "rsa", rsaKey, with("iss", "https://evil"))},
+		{Name: "audience", Token: signToken(t, "RS256", "rsa", rsaKey, with("aud", "other"))},
+		{Name: "no expiration", Token: signToken(t, "RS256", "rsa", rsaKey, with("exp", nil))},
+		{Name: "unknown key", Token: signToken(t, "RS256", "unknown", rsaKey, valid)},
+		{Name: "algorithm mismatch", Token: signToken(t, "ES256", "rsa", ecKey, valid)},
+		{Name: "alg none", Token: signToken(t, "none", "rsa", rsaKey, valid)},
+		{Name: "tampered", Token: signToken(t, "RS256", "rsa", rsaKey, valid) + "A"},
+		{Name: "malformed", Token: "abc.def"},
+	}
+	for _, c := range cases {
+		claims, err := verifier.Verify(c.Token)
+		if c.Valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.Name, err)
+		}
+		if !c.Valid && err == nil {
+			t.Errorf("%s: expected error", c.Name)
+		}
+		if c.Valid && err == nil {
+			if sub, _ := claims.Value("sub"); sub != "42" {
+				t.Errorf("%s: expected sub claim, got %q", c.Name, sub)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+	"isp-convert-service/utils"
+)
+
+const (
+	ClientCertSubjectHeader     = "x-client-cert-subject"
+	ClientCertSanHeader         = "x-client-cert-san"
+	ClientCertFingerprintHeader = "x-client-cert-fingerprint"
+)
+
+var (
+	clientCerts = &clientCertVerifier{required: service.NewCacheableMethodMatcher(nil)}
+)
+
+func init() {
+	utils.ReserveMetadataKeys("mtls", ClientCertSubjectHeader, ClientCertSanHeader, ClientCertFingerprintHeader)
+}
+
+type clientCertVerifier struct {
+	lock     sync.RWMutex
+	enabled  bool
+	required service.MethodMatcher
+}
+
+func ReceiveClientAuthConfiguration(cfg conf.TlsConfig) {
+	clientCerts.lock.Lock()
+	defer clientCerts.lock.Unlock()
+	clientCerts.enabled = cfg.Enable && cfg.ClientAuth.Enable
+	clientCerts.required = service.NewCacheableMethodMatcher(cfg.ClientAuth.RequiredMethodsPatterns)
+}
+
+// VerifyClientCertificate passes the identity of a verified client certificate to backends
+// and rejects requests to methods which require a certificate when it was not presented
+func VerifyClientCertificate(ctx *fasthttp.RequestCtx, method string) error {
+	clientCerts.lock.RLock()
+	enabled, required := clientCerts.enabled, clientCerts.required
+	clientCerts.lock.RUnlock()
+	if !enabled {
+		return nil
+	}
+
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		if required.Match(method) {
+			return status.Error(codes.Unauthenticated, "client certificate required")
+		}
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	utils.SetRequestMetadata(ctx, ClientCertSubjectHeader, asciiSafe(cert.Subject.String()))
+	if san := subjectAltNames(cert); san != "" {
+		utils.SetRequestMetadata(ctx, ClientCertSanHeader, asciiSafe(san))
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	utils.SetRequestMetadata(ctx, ClientCertFingerprintHeader, hex.EncodeToString(fingerprint[:]))
+	return nil
+}
+
+func subjectAltNames(cert *x509.Certificate) string {
+	names := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+	for _, name := range cert.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// asciiSafe percent-encodes bytes which are not allowed in grpc metadata values
+func asciiSafe(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7E || c == '%' {
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0x0F])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
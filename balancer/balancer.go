@@ -0,0 +1,242 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	RoundRobin       = "round_robin"
+	Weighted         = "weighted"
+	LeastOutstanding = "least_outstanding"
+	PowerOfTwo       = "power_of_two"
+	ConsistentHash   = "consistent_hash"
+
+	defaultVirtualNodes = 100
+)
+
+// Picker chooses an instance for a call, the key is used by hashing pickers and ignored by others
+type Picker interface {
+	Pick(key string) *Instance
+}
+
+type Options struct {
+	// VirtualNodes is the number of points of an instance with weight 1 on the hash ring
+	VirtualNodes int
+}
+
+// Validate checks the algorithm name, New does not do it for the empty instance list
+func Validate(algorithm string) error {
+	switch algorithm {
+	case "", RoundRobin, Weighted, LeastOutstanding, PowerOfTwo, ConsistentHash:
+		return nil
+	default:
+		return errors.Errorf("unknown balancing algorithm '%s'", algorithm)
+	}
+}
+
+// New builds a picker over the instances, it returns nil picker for empty list
+func New(algorithm string, instances []*Instance, opts Options) (Picker, error) {
+	if len(instances) == 0 {
+		return nil, nil
+	}
+	switch algorithm {
+	case "", RoundRobin:
+		return &roundRobin{instances: instances}, nil
+	case Weighted:
+		return newWeighted(instances), nil
+	case LeastOutstanding:
+		return &leastOutstanding{instances: instances}, nil
+	case PowerOfTwo:
+		return &powerOfTwo{instances: instances}, nil
+	case ConsistentHash:
+		return NewRing(instances, opts.VirtualNodes, &roundRobin{instances: instances}), nil
+	default:
+		return nil, errors.Errorf("unknown balancing algorithm '%s'", algorithm)
+	}
+}
+
+type roundRobin struct {
+	instances []*Instance
+	next      uint32
+}
+
+func (p *roundRobin) Pick(string) *Instance {
+	start := int(atomic.AddUint32(&p.next, 1)-1) % len(p.instances)
+	for k := 0; k < len(p.instances); k++ {
+		if i := p.instances[(start+k)%len(p.instances)]; i.Available() {
+			return i
+		}
+	}
+	return p.instances[start]
+}
+
+// weighted is the smooth weighted round-robin, it interleaves instances instead of sending bursts to the heaviest
+type weighted struct {
+	lock      sync.Mutex
+	instances []*Instance
+	current   []int
+}
+
+func newWeighted(instances []*Instance) *weighted {
+	return &weighted{instances: instances, current: make([]int, len(instances))}
+}
+
+func (p *weighted) Pick(string) *Instance {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	best, total := -1, 0
+	for i, instance := range p.instances {
+		if !instance.Available() {
+			continue
+		}
+		total += instance.Weight
+		p.current[i] += instance.Weight
+		if best < 0 || p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return p.instances[0]
+	}
+	p.current[best] -= total
+	return p.instances[best]
+}
+
+// leastOutstanding starts the scan from a rotating position, so ties are spread between instances
+type leastOutstanding struct {
+	instances []*Instance
+	next      uint32
+}
+
+func (p *leastOutstanding) Pick(string) *Instance {
+	start := int(atomic.AddUint32(&p.next, 1)) % len(p.instances)
+	best := p.instances[start]
+	for k := 1; k < len(p.instances); k++ {
+		if i := p.instances[(start+k)%len(p.instances)]; less(i, best) {
+			best = i
+		}
+	}
+	return best
+}
+
+type powerOfTwo struct {
+	instances []*Instance
+}
+
+func (p *powerOfTwo) Pick(string) *Instance {
+	n := len(p.instances)
+	if n == 1 {
+		return p.instances[0]
+	}
+	a := rand.Intn(n)
+	b := rand.Intn(n - 1)
+	if b >= a {
+		b++
+	}
+	best := p.instances[a]
+	if less(p.instances[b], best) {
+		best = p.instances[b]
+	}
+	if best.Available() {
+		return best
+	}
+	for _, i := range p.instances {
+		if i.Available() {
+			return i
+		}
+	}
+	return best
+}
+
+// less prefers available instances, then instances with fewer outstanding calls
+func less(a, b *Instance) bool {
+	if a.Available() != b.Available() {
+		return a.Available()
+	}
+	return a.Outstanding() < b.Outstanding()
+}
+
+// Ring is a consistent hash ring, adding or removing an instance moves only keys of its own points
+type Ring struct {
+	points   []uint32
+	owners   []*Instance
+	fallback Picker
+}
+
+// NewRing places weight * virtualNodes points of every instance, calls without key go to the fallback picker
+func NewRing(instances []*Instance, virtualNodes int, fallback Picker) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	type point struct {
+		hash  uint32
+		owner *Instance
+	}
+	points := make([]point, 0, len(instances)*virtualNodes)
+	for _, instance := range instances {
+		for v := 0; v < instance.Weight*virtualNodes; v++ {
+			points = append(points, point{hash: hash(instance.Address + "#" + strconv.Itoa(v)), owner: instance})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	r := &Ring{
+		points:   make([]uint32, len(points)),
+		owners:   make([]*Instance, len(points)),
+		fallback: fallback,
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// Pick walks the ring clockwise from the key until an available instance, so keys of an unavailable
+// instance are spread over the others and return to it when it recovers
+func (r *Ring) Pick(key string) *Instance {
+	if key == "" {
+		return r.fallback.Pick(key)
+	}
+	start := r.search(key)
+	for k := 0; k < len(r.points); k++ {
+		if owner := r.owners[(start+k)%len(r.points)]; owner.Available() {
+			return owner
+		}
+	}
+	return r.owners[start]
+}
+
+func (r *Ring) search(key string) int {
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
+
+// hash mixes fnv-1a with the murmur3 finalizer, plain fnv spreads similar short strings poorly
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
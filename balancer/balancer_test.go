@@ -0,0 +1,125 @@
+package balancer
+
+import (
+	"strconv"
+	"testing"
+)
+
+func instances(weights ...int) []*Instance {
+	list := make([]*Instance, 0, len(weights))
+	for i, w := range weights {
+		list = append(list, NewInstance("10.0.0."+strconv.Itoa(i+1)+":9002", w))
+	}
+	return list
+}
+
+func TestWeighted(t *testing.T) {
+	list := instances(5, 1, 1)
+	p, _ := New(Weighted, list, Options{})
+
+	counts := make(map[*Instance]int)
+	sequence := ""
+	for i := 0; i < 7; i++ {
+		instance := p.Pick("")
+		counts[instance]++
+		sequence += instance.Address[7:8]
+	}
+	if counts[list[0]] != 5 || counts[list[1]] != 1 || counts[list[2]] != 1 {
+		t.Fatalf("unexpected distribution %v", counts)
+	}
+	if sequence != "1121311" {
+		t.Errorf("picks must be interleaved, got %s", sequence)
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	list := instances(1, 1, 1)
+	p, _ := New(LeastOutstanding, list, Options{})
+	list[0].Begin()
+	list[2].Begin()
+	for i := 0; i < 3; i++ {
+		if instance := p.Pick(""); instance != list[1] {
+			t.Fatalf("expected the least loaded instance, got %s", instance.Address)
+		}
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	list := instances(1, 1)
+	p, _ := New(PowerOfTwo, list, Options{})
+	list[0].Begin()
+	for i := 0; i < 10; i++ {
+		if instance := p.Pick(""); instance != list[1] {
+			t.Fatalf("expected the less loaded of two instances, got %s", instance.Address)
+		}
+	}
+}
+
+func TestRing_MinimalRebalancing(t *testing.T) {
+	list := instances(1, 1, 1, 1)
+	before, _ := New(ConsistentHash, list, Options{})
+	after, _ := New(ConsistentHash, list[:3], Options{})
+
+	moved, total := 0, 10000
+	perInstance := make(map[*Instance]int)
+	for i := 0; i < total; i++ {
+		key := "session-" + strconv.Itoa(i)
+		a, b := before.Pick(key), after.Pick(key)
+		perInstance[a]++
+		if a != b {
+			moved++
+			if a != list[3] {
+				t.Fatalf("key %s moved from the remaining instance %s", key, a.Address)
+			}
+		}
+	}
+	for _, instance := range list {
+		if share := perInstance[instance]; share < total/8 || share > total*3/8 {
+			t.Errorf("uneven distribution: %s has %d keys", instance.Address, share)
+		}
+	}
+	if moved != perInstance[list[3]] {
+		t.Errorf("only keys of the removed instance must move, moved %d of %d", moved, perInstance[list[3]])
+	}
+}
+
+func TestNew_UnknownAlgorithm(t *testing.T) {
+	if _, err := New("random", instances(1), Options{}); err == nil {
+		t.Fatal("expected error for unknown algorithm")
+	}
+	if err := Validate("random"); err == nil {
+		t.Fatal("expected validation error for unknown algorithm")
+	}
+	if err := Validate(PowerOfTwo); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRing_UnavailableFallback(t *testing.T) {
+	list := instances(1, 1, 1)
+	ring := NewRing(list, 0, &roundRobin{instances: list})
+
+	pinned := make(map[string]*Instance)
+	for i := 0; i < 300; i++ {
+		key := "tenant-" + strconv.Itoa(i)
+		pinned[key] = ring.Pick(key)
+	}
+
+	list[0].SetConnected(false)
+	for key, instance := range pinned {
+		picked := ring.Pick(key)
+		if instance != list[0] && picked != instance {
+			t.Fatalf("key %s of an available instance must stay pinned", key)
+		}
+		if picked == list[0] {
+			t.Fatalf("key %s must not be sent to an unavailable instance", key)
+		}
+	}
+
+	list[0].SetConnected(true)
+	for key, instance := range pinned {
+		if ring.Pick(key) != instance {
+			t.Fatalf("key %s must return to its instance after recovery", key)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Instance is a router instance, its state survives rebuilding of pickers when the address list is changed
+type Instance struct {
+	Address string
+	Weight  int
+
+	outstanding  int32
+	disconnected int32
+	unhealthy    int32
+	ejectedUntil int64
+
+	consecutiveFailures int32
+	requests            int32
+	failures            int32
+	ejections           int32
+}
+
+func NewInstance(address string, weight int) *Instance {
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Instance{Address: address, Weight: weight}
+}
+
+// Begin counts a call to the instance, End must be called when the call is finished
+func (i *Instance) Begin() {
+	atomic.AddInt32(&i.outstanding, 1)
+}
+
+func (i *Instance) End() {
+	atomic.AddInt32(&i.outstanding, -1)
+}
+
+func (i *Instance) Outstanding() int {
+	return int(atomic.LoadInt32(&i.outstanding))
+}
+
+// SetConnected is driven by the connectivity state of the connection to the instance
+func (i *Instance) SetConnected(connected bool) {
+	setFlag(&i.disconnected, !connected)
+}
+
+// SetHealthy is driven by active health checks
+func (i *Instance) SetHealthy(healthy bool) {
+	setFlag(&i.unhealthy, !healthy)
+}
+
+func (i *Instance) Healthy() bool {
+	return atomic.LoadInt32(&i.unhealthy) == 0
+}
+
+func (i *Instance) Ejected(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&i.ejectedUntil)
+}
+
+// Available reports whether calls should be sent to the instance, pickers fall back to unavailable
+// instances only if there are no available ones
+func (i *Instance) Available() bool {
+	return atomic.LoadInt32(&i.disconnected) == 0 && atomic.LoadInt32(&i.unhealthy) == 0 && !i.Ejected(time.Now())
+}
+
+// CopyState moves availability and ejection history of the instance replaced on configuration change
+func (i *Instance) CopyState(from *Instance) {
+	atomic.StoreInt32(&i.disconnected, atomic.LoadInt32(&from.disconnected))
+	atomic.StoreInt32(&i.unhealthy, atomic.LoadInt32(&from.unhealthy))
+	atomic.StoreInt64(&i.ejectedUntil, atomic.LoadInt64(&from.ejectedUntil))
+	atomic.StoreInt32(&i.ejections, atomic.LoadInt32(&from.ejections))
+}
+
+func setFlag(flag *int32, value bool) {
+	if value {
+		atomic.StoreInt32(flag, 1)
+	} else {
+		atomic.StoreInt32(flag, 0)
+	}
+}
@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type OutlierSettings struct {
+	ConsecutiveFailures int
+	FailureRate         float64
+	MinRequests         int
+	BaseEjectionTime    time.Duration
+	MaxEjectionTime     time.Duration
+	MaxEjectedPercent   int
+}
+
+// OutlierDetector ejects instances by results of live calls: at once after consecutive failures
+// and on sweep by the failure rate of the interval. An ejection lasts base time multiplied by the number
+// of ejections in a row, so flapping instances stay out longer
+type OutlierDetector struct {
+	settings OutlierSettings
+	now      func() time.Time
+	lock     sync.Mutex
+}
+
+func NewOutlierDetector(settings OutlierSettings) *OutlierDetector {
+	return &OutlierDetector{settings: settings, now: time.Now}
+}
+
+// Record reports whether the result ejected the instance
+func (d *OutlierDetector) Record(i *Instance, failed bool, all []*Instance) bool {
+	atomic.AddInt32(&i.requests, 1)
+	if !failed {
+		atomic.StoreInt32(&i.consecutiveFailures, 0)
+		return false
+	}
+	atomic.AddInt32(&i.failures, 1)
+	consecutive := atomic.AddInt32(&i.consecutiveFailures, 1)
+	if d.settings.ConsecutiveFailures <= 0 || int(consecutive) < d.settings.ConsecutiveFailures {
+		return false
+	}
+	return d.eject(i, all)
+}
+
+// Sweep ejects instances by the failure rate since the previous sweep and resets the interval counters
+func (d *OutlierDetector) Sweep(all []*Instance) []*Instance {
+	ejected := make([]*Instance, 0)
+	now := d.now()
+	for _, i := range all {
+		requests := atomic.SwapInt32(&i.requests, 0)
+		failures := atomic.SwapInt32(&i.failures, 0)
+		if i.Ejected(now) {
+			continue
+		}
+		if d.settings.FailureRate > 0 && int(requests) >= d.settings.MinRequests &&
+			float64(failures)/float64(requests) >= d.settings.FailureRate {
+			if d.eject(i, all) {
+				ejected = append(ejected, i)
+			}
+			continue
+		}
+		// an interval without ejection forgives one of the previous ejections
+		if atomic.LoadInt32(&i.ejections) > 0 {
+			atomic.AddInt32(&i.ejections, -1)
+		}
+	}
+	return ejected
+}
+
+// eject keeps at most the max ejected percent of instances ejected. The cap counts outlier ejections only,
+// instances which are disconnected or fail health checks are unavailable regardless of it
+func (d *OutlierDetector) eject(i *Instance, all []*Instance) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := d.now()
+	if i.Ejected(now) {
+		return false
+	}
+	ejected := 0
+	for _, other := range all {
+		if other.Ejected(now) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > d.settings.MaxEjectedPercent*len(all) {
+		return false
+	}
+
+	ejections := atomic.AddInt32(&i.ejections, 1)
+	duration := d.settings.BaseEjectionTime * time.Duration(ejections)
+	if duration > d.settings.MaxEjectionTime {
+		duration = d.settings.MaxEjectionTime
+	}
+	atomic.StoreInt64(&i.ejectedUntil, now.Add(duration).UnixNano())
+	atomic.StoreInt32(&i.consecutiveFailures, 0)
+	return true
+}
@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	now := time.Now()
+	d := NewOutlierDetector(OutlierSettings{
+		ConsecutiveFailures: 3,
+		FailureRate:         0.5,
+		MinRequests:         10,
+		BaseEjectionTime:    10 * time.Second,
+		MaxEjectionTime:     15 * time.Second,
+		MaxEjectedPercent:   50,
+	})
+	d.now = func() time.Time { return now }
+	list := instances(1, 1, 1, 1)
+
+	for k := 0; k < 2; k++ {
+		d.Record(list[0], true, list)
+	}
+	d.Record(list[0], false, list)
+	if d.Record(list[0], true, list) {
+		t.Fatal("success must reset consecutive failures")
+	}
+	d.Record(list[0], true, list)
+	if !d.Record(list[0], true, list) || !list[0].Ejected(now) {
+		t.Fatal("instance must be ejected after consecutive failures")
+	}
+	if list[0].Ejected(now.Add(10 * time.Second)) {
+		t.Fatal("first ejection must last base time")
+	}
+
+	for k := 0; k < 10; k++ {
+		d.Record(list[1], k%2 == 0, list)
+		d.Record(list[2], k%2 == 1, list)
+		d.Record(list[3], false, list)
+	}
+	ejected := d.Sweep(list)
+	if len(ejected) != 1 || !list[1].Ejected(now) && !list[2].Ejected(now) {
+		t.Fatalf("only one more instance may be ejected within max ejected percent, got %d", len(ejected))
+	}
+	if list[3].Ejected(now) {
+		t.Fatal("healthy instance must not be ejected")
+	}
+
+	now = now.Add(20 * time.Second)
+	for k := 0; k < 3; k++ {
+		d.Record(list[0], true, list)
+	}
+	if !list[0].Ejected(now) || list[0].Ejected(now.Add(15*time.Second)) {
+		t.Fatal("repeated ejection must be longer but capped by max ejection time")
+	}
+}
@@ -0,0 +1,174 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type Settings struct {
+	ConsecutiveFailures int
+	ErrorRate           float64
+	MinRequests         int
+	Window              time.Duration
+	OpenTimeout         time.Duration
+	HalfOpenProbes      int
+}
+
+// Breaker opens after consecutive failures or when the failure rate of the window exceeds the threshold,
+// after the open timeout it lets a limited number of probe calls through and closes if all of them succeed
+type Breaker struct {
+	settings Settings
+	now      func() time.Time
+
+	lock         sync.Mutex
+	state        State
+	windowStart  time.Time
+	requests     int
+	failures     int
+	consecutive  int
+	openedAt     time.Time
+	probes       int
+	probesPassed int
+	lastCall     time.Time
+	onChange     func(State)
+}
+
+func NewBreaker(settings Settings, onChange func(State)) *Breaker {
+	return &Breaker{settings: settings, now: time.Now, onChange: onChange}
+}
+
+// Allow reports whether the call may proceed, the returned function must be called with the call result
+func (b *Breaker) Allow() (func(failed bool), bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+	b.lastCall = now
+	switch b.state {
+	case StateOpen:
+		if now.Sub(b.openedAt) < b.settings.OpenTimeout {
+			return nil, false
+		}
+		b.setState(StateHalfOpen)
+		b.probes, b.probesPassed = 0, 0
+		fallthrough
+	case StateHalfOpen:
+		if b.probes >= b.settings.HalfOpenProbes {
+			return nil, false
+		}
+		b.probes++
+		return b.probeDone, true
+	default:
+		if now.Sub(b.windowStart) >= b.settings.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		return b.done, true
+	}
+}
+
+func (b *Breaker) done(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != StateClosed {
+		return
+	}
+
+	b.requests++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+	if b.settings.ConsecutiveFailures > 0 && b.consecutive >= b.settings.ConsecutiveFailures {
+		b.open()
+		return
+	}
+	if b.settings.ErrorRate > 0 && b.requests >= b.settings.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.settings.ErrorRate {
+		b.open()
+	}
+}
+
+func (b *Breaker) probeDone(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != StateHalfOpen {
+		return
+	}
+
+	if failed {
+		b.open()
+		return
+	}
+	b.probesPassed++
+	if b.probesPassed >= b.settings.HalfOpenProbes {
+		b.setState(StateClosed)
+		b.windowStart, b.requests, b.failures, b.consecutive = b.now(), 0, 0, 0
+	}
+}
+
+// Idle reports whether the breaker is closed and got no calls for the timeout, so it can be dropped
+func (b *Breaker) Idle(now time.Time, timeout time.Duration) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state == StateClosed && now.Sub(b.lastCall) >= timeout
+}
+
+func (b *Breaker) open() {
+	b.setState(StateOpen)
+	b.openedAt = b.now()
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
+
+type Snapshot struct {
+	State               string     `json:"state"`
+	Requests            int        `json:"requests"`
+	Failures            int        `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+}
+
+func (b *Breaker) Snapshot() Snapshot {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	s := Snapshot{
+		State:               b.state.String(),
+		Requests:            b.requests,
+		Failures:            b.failures,
+		ConsecutiveFailures: b.consecutive,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	changes := make([]State, 0)
+	b := NewBreaker(Settings{
+		ConsecutiveFailures: 3,
+		ErrorRate:           0.5,
+		MinRequests:         4,
+		Window:              time.Minute,
+		OpenTimeout:         10 * time.Second,
+		HalfOpenProbes:      2,
+	}, func(s State) { changes = append(changes, s) })
+	b.now = func() time.Time { return now }
+
+	call := func(failed bool) bool {
+		done, ok := b.Allow()
+		if ok {
+			done(failed)
+		}
+		return ok
+	}
+
+	call(true)
+	call(false)
+	call(true)
+	if b.state != StateClosed {
+		t.Fatal("breaker must stay closed below min requests")
+	}
+	call(true)
+	if b.state != StateOpen {
+		t.Fatal("breaker must open by error rate")
+	}
+	if call(false) {
+		t.Fatal("open breaker must fail fast")
+	}
+
+	now = now.Add(10 * time.Second)
+	probe, ok := b.Allow()
+	if !ok || b.state != StateHalfOpen {
+		t.Fatal("breaker must half-open after timeout")
+	}
+	if !call(false) {
+		t.Fatal("second probe must be allowed")
+	}
+	if call(false) {
+		t.Fatal("calls over probes must be rejected")
+	}
+	probe(false)
+	if b.state != StateClosed {
+		t.Fatal("breaker must close after successful probes")
+	}
+
+	for i := 0; i < 3; i++ {
+		call(true)
+	}
+	if b.state != StateOpen {
+		t.Fatal("breaker must open by consecutive failures")
+	}
+	now = now.Add(10 * time.Second)
+	call(true)
+	if b.state != StateOpen {
+		t.Fatal("failed probe must open breaker again")
+	}
+
+	expected := []State{StateOpen, StateHalfOpen, StateClosed, StateOpen, StateHalfOpen, StateOpen}
+	if len(changes) != len(expected) {
+		t.Fatalf("unexpected state changes %v", changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("unexpected state changes %v", changes)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package breaker
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/admin"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+)
+
+const (
+	// idleTimeout drops closed breakers of methods without calls, so random paths do not pile up
+	idleTimeout = 10 * time.Minute
+)
+
+var (
+	registry     *Registry
+	registryCfg  conf.CircuitBreakerConfig
+	registryLock sync.RWMutex
+
+	failureCodes = map[codes.Code]bool{
+		codes.Unavailable:      true,
+		codes.DeadlineExceeded: true,
+		codes.Internal:         true,
+	}
+)
+
+func init() {
+	admin.Handle("GET", "/breakers", handleBreakers)
+}
+
+// Registry creates a breaker per router method on the first failed call, so methods unknown to the router
+// get no breakers, and drops breakers which stayed closed without calls for the idle timeout.
+// Only unary calls are protected, uploads and downloads of files are passed to the router as is
+type Registry struct {
+	settings Settings
+	methods  service.MethodMatcher
+	all      bool
+	now      func() time.Time
+
+	lock      sync.RWMutex
+	breakers  map[string]*Breaker
+	lastSweep time.Time
+	// closed is set once the registry is replaced, its breakers must not register gauges anymore
+	closed int32
+}
+
+func NewRegistry(cfg conf.CircuitBreakerConfig) *Registry {
+	return &Registry{
+		settings: Settings{
+			ConsecutiveFailures: cfg.ConsecutiveFailures,
+			ErrorRate:           float64(cfg.ErrorRatePercent) / 100,
+			MinRequests:         cfg.GetMinRequests(),
+			Window:              cfg.GetWindow(),
+			OpenTimeout:         cfg.GetOpenTimeout(),
+			HalfOpenProbes:      cfg.GetHalfOpenProbes(),
+		},
+		methods:  service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+		all:      len(cfg.MethodsPatterns) == 0,
+		now:      time.Now,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+func (r *Registry) find(method string) *Breaker {
+	r.lock.RLock()
+	b := r.breakers[method]
+	r.lock.RUnlock()
+	return b
+}
+
+func (r *Registry) breaker(method string) *Breaker {
+	if b := r.find(method); b != nil {
+		return b
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if b, ok := r.breakers[method]; ok {
+		return b
+	}
+	r.sweep()
+	b := NewBreaker(r.settings, func(state State) {
+		if atomic.LoadInt32(&r.closed) == 0 {
+			service.GetMetrics().UpdateBreakerState(method, int(state))
+		}
+	})
+	b.now = r.now
+	r.breakers[method] = b
+	return b
+}
+
+// sweep drops idle breakers at most once per idle timeout, it must be called under the lock
+func (r *Registry) sweep() {
+	now := r.now()
+	if now.Sub(r.lastSweep) < idleTimeout {
+		return
+	}
+	r.lastSweep = now
+	for method, b := range r.breakers {
+		if b.Idle(now, idleTimeout) {
+			delete(r.breakers, method)
+			service.GetMetrics().RemoveBreakerState(method)
+		}
+	}
+}
+
+// close unregisters gauges of all breakers of the replaced registry
+func (r *Registry) close() {
+	atomic.StoreInt32(&r.closed, 1)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for method := range r.breakers {
+		service.GetMetrics().RemoveBreakerState(method)
+	}
+}
+
+// ReceiveConfiguration keeps breaker states if the configuration is not changed
+func ReceiveConfiguration(cfg conf.CircuitBreakerConfig) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if reflect.DeepEqual(cfg, registryCfg) {
+		return
+	}
+	registryCfg = cfg
+	if registry != nil {
+		registry.close()
+	}
+	if cfg.Enable {
+		registry = NewRegistry(cfg)
+	} else {
+		registry = nil
+	}
+}
+
+func current() *Registry {
+	registryLock.RLock()
+	r := registry
+	registryLock.RUnlock()
+	return r
+}
+
+// Allow fails fast with Unavailable while the breaker of the method is open,
+// the returned function must be called with the result of the router call.
+// The method is expected without the query, otherwise every query string would get its own breaker
+func Allow(method string) (func(err error), error) {
+	r := current()
+	if r == nil || (!r.all && !r.methods.Match(method)) {
+		return func(error) {}, nil
+	}
+	b := r.find(method)
+	if b == nil {
+		return func(err error) {
+			if err != nil && failureCodes[status.Code(err)] {
+				if done, ok := r.breaker(method).Allow(); ok {
+					done(true)
+				}
+			}
+		}, nil
+	}
+	done, ok := b.Allow()
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "circuit breaker of method %s is open", method)
+	}
+	return func(err error) {
+		done(err != nil && failureCodes[status.Code(err)])
+	}, nil
+}
+
+type breakerState struct {
+	Method string `json:"method"`
+	Snapshot
+}
+
+func handleBreakers(ctx *fasthttp.RequestCtx) {
+	r := current()
+	if r == nil {
+		admin.SendJson(ctx, http.StatusNotFound, map[string]string{"error": "circuit breakers are disabled"})
+		return
+	}
+
+	r.lock.RLock()
+	result := make([]breakerState, 0, len(r.breakers))
+	for method, b := range r.breakers {
+		result = append(result, breakerState{Method: method, Snapshot: b.Snapshot()})
+	}
+	r.lock.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Method < result[j].Method
+	})
+	admin.SendJson(ctx, http.StatusOK, result)
+}
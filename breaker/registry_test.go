@@ -0,0 +1,76 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/integration-system/isp-lib/metric"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+)
+
+func TestRegistry_Breakers(t *testing.T) {
+	service.InitMetrics()
+	r := NewRegistry(conf.CircuitBreakerConfig{Enable: true, ConsecutiveFailures: 5})
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time { return now }
+	registryLock.Lock()
+	registry = r
+	registryLock.Unlock()
+	defer func() {
+		registryLock.Lock()
+		registry = nil
+		registryLock.Unlock()
+	}()
+
+	call := func(method string, err error) {
+		done, allowErr := Allow(method)
+		if allowErr != nil {
+			t.Fatal(allowErr)
+		}
+		done(err)
+	}
+	call("catalog/item/get", nil)
+	call("random/path/1", status.Error(codes.Unimplemented, "unknown method"))
+	if len(r.breakers) != 0 {
+		t.Fatalf("breakers must be created only by failed router calls, got %d", len(r.breakers))
+	}
+	call("catalog/item/get", status.Error(codes.Unavailable, "router is unavailable"))
+	if b := r.find("catalog/item/get"); b == nil || b.Snapshot().ConsecutiveFailures != 1 {
+		t.Fatal("failed call must create the breaker and count the failure")
+	}
+
+	now = now.Add(idleTimeout)
+	call("reports/daily/get", status.Error(codes.Unavailable, "router is unavailable"))
+	if r.find("catalog/item/get") != nil {
+		t.Error("idle breaker must be dropped")
+	}
+	if r.find("reports/daily/get") == nil {
+		t.Error("breaker of the failed call must stay")
+	}
+}
+
+func TestReceiveConfiguration_RemovesGauges(t *testing.T) {
+	service.InitMetrics()
+	ReceiveConfiguration(conf.CircuitBreakerConfig{Enable: true, ConsecutiveFailures: 1})
+	defer ReceiveConfiguration(conf.CircuitBreakerConfig{})
+
+	done, err := Allow("catalog/item/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(status.Error(codes.Unavailable, "router is unavailable"))
+	if _, err := Allow("catalog/item/get"); err == nil {
+		t.Fatal("breaker must open on the first failure")
+	}
+	if metric.GetRegistry().Get("grpc.breaker.state_catalog/item/get") == nil {
+		t.Fatal("opened breaker must report its state")
+	}
+
+	ReceiveConfiguration(conf.CircuitBreakerConfig{Enable: true, ConsecutiveFailures: 2})
+	if metric.GetRegistry().Get("grpc.breaker.state_catalog/item/get") != nil {
+		t.Error("gauges of the replaced registry must be removed")
+	}
+}
@@ -0,0 +1,130 @@
+package bulkhead
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+)
+
+var (
+	bulkheads     []*Bulkhead
+	bulkheadsLock sync.RWMutex
+)
+
+// Bulkhead limits in-flight calls, calls over the limit wait in a bounded queue until a slot is released or timeout
+type Bulkhead struct {
+	name         string
+	methods      service.MethodMatcher
+	slots        chan struct{}
+	maxQueue     int32
+	waiting      int32
+	queueTimeout time.Duration
+	config       conf.BulkheadConfig
+	// queued is called when a call starts waiting in the queue
+	queued func()
+}
+
+func NewBulkhead(name string, patterns []string, maxConcurrent, maxQueue int, queueTimeout time.Duration) *Bulkhead {
+	return &Bulkhead{
+		name:         name,
+		methods:      service.NewCacheableMethodMatcher(patterns),
+		slots:        make(chan struct{}, maxConcurrent),
+		maxQueue:     int32(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire returns a function which must be called when the call is finished
+func (b *Bulkhead) Acquire() (func(), bool) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, true
+	default:
+	}
+
+	if atomic.AddInt32(&b.waiting, 1) > b.maxQueue {
+		atomic.AddInt32(&b.waiting, -1)
+		return nil, false
+	}
+	defer atomic.AddInt32(&b.waiting, -1)
+	if b.queued != nil {
+		b.queued()
+	}
+
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+func (b *Bulkhead) release() {
+	<-b.slots
+}
+
+// ReceiveConfiguration keeps bulkheads with unchanged configuration, so their slots taken by calls in flight are not lost
+func ReceiveConfiguration(list []conf.BulkheadConfig) error {
+	bulkheadsLock.RLock()
+	prev := make(map[string]*Bulkhead, len(bulkheads))
+	for _, b := range bulkheads {
+		prev[b.name] = b
+	}
+	bulkheadsLock.RUnlock()
+
+	next := make([]*Bulkhead, 0, len(list))
+	for i, cfg := range list {
+		if cfg.MaxConcurrent <= 0 {
+			return errors.Errorf("bulkhead %d: max concurrent calls must be positive", i)
+		}
+		if len(cfg.MethodsPatterns) == 0 {
+			return errors.Errorf("bulkhead %d: methods are not specified", i)
+		}
+		name := cfg.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if b, ok := prev[name]; ok && reflect.DeepEqual(b.config, cfg) {
+			next = append(next, b)
+			continue
+		}
+		b := NewBulkhead(name, cfg.MethodsPatterns, cfg.MaxConcurrent, cfg.MaxQueue, cfg.GetQueueTimeout())
+		b.config = cfg
+		next = append(next, b)
+	}
+
+	// calls in flight release slots of the bulkheads they were admitted by, changed ones start empty
+	bulkheadsLock.Lock()
+	bulkheads = next
+	bulkheadsLock.Unlock()
+	return nil
+}
+
+// Acquire takes a slot in the first bulkhead matching the method, methods without bulkhead are not limited
+func Acquire(method string) (func(), error) {
+	bulkheadsLock.RLock()
+	list := bulkheads
+	bulkheadsLock.RUnlock()
+
+	for _, b := range list {
+		if !b.methods.Match(method) {
+			continue
+		}
+		release, ok := b.Acquire()
+		if !ok {
+			return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent calls, bulkhead '%s' is full", b.name)
+		}
+		return release, nil
+	}
+	return func() {}, nil
+}
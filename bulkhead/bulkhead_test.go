@@ -0,0 +1,85 @@
+package bulkhead
+
+import (
+	"testing"
+	"time"
+
+	"isp-convert-service/conf"
+)
+
+func TestBulkhead_Acquire(t *testing.T) {
+	b := NewBulkhead("reports", []string{"reports/*"}, 1, 1, 50*time.Millisecond)
+	waiting := make(chan struct{}, 1)
+	b.queued = func() {
+		waiting <- struct{}{}
+	}
+
+	release, ok := b.Acquire()
+	if !ok {
+		t.Fatal("first call must be admitted")
+	}
+
+	queued := make(chan bool)
+	go func() {
+		r, ok := b.Acquire()
+		if ok {
+			r()
+		}
+		queued <- ok
+	}()
+	<-waiting
+
+	if _, ok := b.Acquire(); ok {
+		t.Fatal("call over the queue size must be rejected")
+	}
+	release()
+	if !<-queued {
+		t.Fatal("queued call must be admitted after release")
+	}
+
+	release, _ = b.Acquire()
+	defer release()
+	start := time.Now()
+	if _, ok := b.Acquire(); ok {
+		t.Fatal("queued call must time out")
+	}
+	<-waiting
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("queued call is rejected before timeout: %v", elapsed)
+	}
+}
+
+func TestReceiveConfiguration_KeepsSlots(t *testing.T) {
+	reports := conf.BulkheadConfig{Name: "reports", MethodsPatterns: []string{"reports/*/*"}, MaxConcurrent: 1}
+	exports := conf.BulkheadConfig{Name: "exports", MethodsPatterns: []string{"exports/*/*"}, MaxConcurrent: 1}
+	if err := ReceiveConfiguration([]conf.BulkheadConfig{reports, exports}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ReceiveConfiguration(nil)
+	}()
+
+	releaseReport, err := Acquire("reports/daily/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseReport()
+	releaseExport, err := Acquire("exports/daily/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseExport()
+
+	exports.MaxConcurrent = 2
+	if err := ReceiveConfiguration([]conf.BulkheadConfig{reports, exports}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire("reports/daily/get"); err == nil {
+		t.Error("unchanged bulkhead must keep slots of calls in flight")
+	}
+	release, err := Acquire("exports/daily/get")
+	if err != nil {
+		t.Fatal("changed bulkhead is expected to start empty")
+	}
+	release()
+}
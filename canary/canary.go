@@ -0,0 +1,188 @@
+package canary
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"isp-convert-service/conf"
+	"isp-convert-service/invoker"
+	"isp-convert-service/service"
+)
+
+const (
+	ModePercent  = "percent"
+	ModeHeader   = "header"
+	ModeCookie   = "cookie"
+	ModeUserHash = "user_hash"
+
+	VariantPrimary = "primary"
+	VariantCanary  = "canary"
+
+	userValueKey = "canary.choice"
+)
+
+var (
+	splits     []*Split
+	splitsLock sync.RWMutex
+)
+
+// Split sends a part of the calls of matching methods to the canary cluster instead of the primary one
+type Split struct {
+	name    string
+	methods service.MethodMatcher
+	primary string
+	canary  string
+	mode    string
+	key     string
+	value   string
+	percent int
+}
+
+// choice is the variant of the split chosen for a request
+type choice struct {
+	split   string
+	variant string
+	cluster string
+}
+
+func NewSplit(name string, patterns []string, primary, canary, mode, key, value string, percent int) *Split {
+	if mode == "" {
+		mode = ModePercent
+	}
+	return &Split{
+		name:    name,
+		methods: service.NewCacheableMethodMatcher(patterns),
+		primary: primary,
+		canary:  canary,
+		mode:    mode,
+		key:     key,
+		value:   value,
+		percent: percent,
+	}
+}
+
+// IsCanary decides the variant by the value of the key taken from the request and a random number in [0, 100)
+func (s *Split) IsCanary(key string, random int) bool {
+	switch s.mode {
+	case ModeHeader, ModeCookie:
+		if s.value == "" {
+			return key != ""
+		}
+		return key == s.value
+	case ModeUserHash:
+		return key != "" && bucket(key) < s.percent
+	default:
+		return random < s.percent
+	}
+}
+
+func (s *Split) requestKey(ctx *fasthttp.RequestCtx) string {
+	switch s.mode {
+	case ModeHeader, ModeUserHash:
+		return string(ctx.Request.Header.Peek(s.key))
+	case ModeCookie:
+		return string(ctx.Request.Header.Cookie(s.key))
+	default:
+		return ""
+	}
+}
+
+// bucket places the key into one of 100 buckets, the same user always gets the same variant
+func bucket(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+func ReceiveConfiguration(list []conf.TrafficSplitConfig) error {
+	next := make([]*Split, 0, len(list))
+	for i, cfg := range list {
+		name := cfg.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if len(cfg.MethodsPatterns) == 0 {
+			return errors.Errorf("split %s: methods are not specified", name)
+		}
+		for _, cluster := range []string{cfg.PrimaryCluster, cfg.CanaryCluster} {
+			if !invoker.HasCluster(cluster) {
+				return errors.Errorf("split %s: unknown cluster '%s'", name, cluster)
+			}
+		}
+		switch cfg.Mode {
+		case "", ModePercent:
+		case ModeHeader, ModeCookie, ModeUserHash:
+			if cfg.Key == "" {
+				return errors.Errorf("split %s: key is required for mode '%s'", name, cfg.Mode)
+			}
+		default:
+			return errors.Errorf("split %s: unknown mode '%s'", name, cfg.Mode)
+		}
+		if cfg.Percent < 0 || cfg.Percent > 100 {
+			return errors.Errorf("split %s: percent must be in range [0, 100]", name)
+		}
+		next = append(next, NewSplit(
+			name, cfg.MethodsPatterns, cfg.PrimaryCluster, cfg.CanaryCluster, cfg.Mode, cfg.Key, cfg.Value, cfg.Percent,
+		))
+	}
+
+	splitsLock.Lock()
+	splits = next
+	splitsLock.Unlock()
+	return nil
+}
+
+// ClusterSplit returns the name of the first split sending calls to the cluster, or empty string
+func ClusterSplit(cluster string) string {
+	splitsLock.RLock()
+	defer splitsLock.RUnlock()
+	for _, s := range splits {
+		if s.primary == cluster || s.canary == cluster {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// Choose picks the variant of the first split matching the method and remembers it in the request context.
+// Clusters are checked again on every call, a split whose cluster was removed by a later reload is skipped,
+// so its calls are neither sent to another cluster nor recorded as a variant
+func Choose(ctx *fasthttp.RequestCtx, method string) {
+	splitsLock.RLock()
+	list := splits
+	splitsLock.RUnlock()
+
+	for _, s := range list {
+		if !s.methods.Match(method) || !invoker.HasCluster(s.primary) || !invoker.HasCluster(s.canary) {
+			continue
+		}
+		c := choice{split: s.name, variant: VariantPrimary, cluster: s.primary}
+		if s.IsCanary(s.requestKey(ctx), rand.Intn(100)) {
+			c.variant, c.cluster = VariantCanary, s.canary
+		}
+		ctx.SetUserValue(userValueKey, c)
+		return
+	}
+}
+
+// Cluster returns the cluster chosen for the request, empty value means the cluster serving the method
+func Cluster(ctx *fasthttp.RequestCtx) string {
+	c, _ := ctx.UserValue(userValueKey).(choice)
+	return c.cluster
+}
+
+// Record reports the response status and latency of the request to the metrics of the chosen variant
+func Record(ctx *fasthttp.RequestCtx, latency time.Duration) {
+	c, ok := ctx.UserValue(userValueKey).(choice)
+	if !ok {
+		return
+	}
+	failed := ctx.Response.StatusCode() != http.StatusOK
+	service.GetMetrics().UpdateSplit(c.split, c.variant, failed, latency/1e6)
+}
@@ -0,0 +1,88 @@
+package canary
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/integration-system/isp-lib/structure"
+	"github.com/valyala/fasthttp"
+	"isp-convert-service/conf"
+	"isp-convert-service/invoker"
+)
+
+func TestSplit_Percent(t *testing.T) {
+	s := NewSplit("test", []string{"*"}, "router", "next", "", "", "", 30)
+	canary := 0
+	for random := 0; random < 100; random++ {
+		if s.IsCanary("", random) {
+			canary++
+		}
+	}
+	if canary != 30 {
+		t.Fatalf("expected 30 canary calls of 100, got %d", canary)
+	}
+}
+
+func TestSplit_Header(t *testing.T) {
+	s := NewSplit("test", []string{"*"}, "router", "next", ModeHeader, "x-canary", "", 0)
+	if !s.IsCanary("1", 99) || s.IsCanary("", 0) {
+		t.Fatal("any header value is expected to select canary")
+	}
+	s = NewSplit("test", []string{"*"}, "router", "next", ModeCookie, "release", "beta", 0)
+	if !s.IsCanary("beta", 99) || s.IsCanary("stable", 0) {
+		t.Fatal("only configured cookie value is expected to select canary")
+	}
+}
+
+func TestSplit_UserHash(t *testing.T) {
+	s := NewSplit("test", []string{"*"}, "router", "next", ModeUserHash, "x-user-id", "", 20)
+	canary := 0
+	for i := 0; i < 10000; i++ {
+		user := "user-" + strconv.Itoa(i)
+		first := s.IsCanary(user, 0)
+		for random := 1; random < 100; random += 33 {
+			if s.IsCanary(user, random) != first {
+				t.Fatalf("variant of %s depends on random value", user)
+			}
+		}
+		if first {
+			canary++
+		}
+	}
+	if canary < 1800 || canary > 2200 {
+		t.Fatalf("expected about 2000 canary users of 10000, got %d", canary)
+	}
+	if s.IsCanary("", 0) {
+		t.Fatal("requests without user are expected to go to primary")
+	}
+}
+
+func TestChoose_RemovedCluster(t *testing.T) {
+	next := conf.RouterClusterConfig{Name: "next", Addresses: []structure.AddressConfiguration{{IP: "127.0.0.1", Port: "9001"}}}
+	if err := invoker.ReceiveClustersConfiguration([]conf.RouterClusterConfig{next}, conf.FailoverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = invoker.ReceiveClustersConfiguration(nil, conf.FailoverConfig{}) }()
+	err := ReceiveConfiguration([]conf.TrafficSplitConfig{
+		{Name: "next", MethodsPatterns: []string{"*/*/*"}, PrimaryCluster: "router", CanaryCluster: "next", Percent: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ReceiveConfiguration(nil) }()
+
+	ctx := &fasthttp.RequestCtx{}
+	Choose(ctx, "catalog/item/get")
+	if Cluster(ctx) != "next" {
+		t.Fatalf("expected canary cluster, got '%s'", Cluster(ctx))
+	}
+
+	if err := invoker.ReceiveClustersConfiguration(nil, conf.FailoverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx = &fasthttp.RequestCtx{}
+	Choose(ctx, "catalog/item/get")
+	if _, chosen := ctx.UserValue(userValueKey).(choice); chosen {
+		t.Error("split with a removed cluster must be skipped")
+	}
+}
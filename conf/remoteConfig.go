@@ -13,6 +13,66 @@ const (
 	defaultSyncTimeout   = 30 * time.Second
 	defaultStreamTimeout = 60 * time.Second
 
+	defaultJwtHeader    = "Authorization"
+	defaultJwksRefresh  = 5 * time.Minute
+	defaultJwtClockSkew = 60 * time.Second
+
+	defaultHmacClockSkew = 5 * time.Minute
+	defaultApiKeyHeader  = "X-Api-Key"
+
+	defaultQuotaHeader      = "X-Application-Token"
+	defaultQuotaStoreFile   = "/var/lib/isp-convert-service/quotas.json"
+	defaultQuotaFlushPeriod = 5 * time.Second
+
+	defaultBulkheadQueueTimeout = time.Second
+
+	defaultAdaptiveInitialLimit = 20
+	defaultAdaptiveMinLimit     = 1
+	defaultAdaptiveMaxLimit     = 1000
+	defaultAdaptiveTolerance    = 1.5
+
+	defaultPriorityShedAtPercent = 100
+
+	defaultBreakerMinRequests    = 20
+	defaultBreakerWindow         = 10 * time.Second
+	defaultBreakerOpenTimeout    = 30 * time.Second
+	defaultBreakerHalfOpenProbes = 1
+
+	defaultRetryBudgetPercent     = 20
+	defaultRetryMinPerSecond      = 10
+	defaultRetryMaxAttempts       = 3
+	defaultRetryInitialBackoff    = 50 * time.Millisecond
+	defaultRetryMaxBackoff        = time.Second
+	defaultRetryBackoffMultiplier = 2.0
+
+	defaultKeepaliveTimeout = 20 * time.Second
+	defaultFailoverHeader   = "X-Router-Failover"
+
+	defaultMirrorSamplePercent = 100
+	defaultMirrorTimeout       = 5 * time.Second
+	defaultMirrorMaxConcurrent = 100
+
+	defaultHealthCheckInterval      = 5 * time.Second
+	defaultHealthCheckTimeout       = time.Second
+	defaultUnhealthyThreshold       = 2
+	defaultHealthyThreshold         = 1
+	defaultOutlierInterval          = 10 * time.Second
+	defaultOutlierMinRequests       = 20
+	defaultOutlierBaseEjectionTime  = 30 * time.Second
+	defaultOutlierMaxEjectionTime   = 5 * time.Minute
+	defaultOutlierMaxEjectedPercent = 50
+
+	defaultCertificateReloadPeriod = 30 * time.Second
+
+	defaultHedgePercentile    = 95
+	defaultHedgeDelay         = 100 * time.Millisecond
+	defaultHedgeBudgetPercent = 10
+	defaultHedgeMinPerSecond  = 1
+
+	defaultMeteringDirectory   = "/var/log/isp-convert-service/usage"
+	defaultMeteringFormat      = "csv"
+	defaultMeteringFlushPeriod = 60 * time.Second
+
 	defaultBufferSize          = 4 * KB
 	defaultMaxRequestBodySize  = 512 * MB
 	DefaultMaxResponseBodySize = 32 * MB
@@ -28,6 +88,35 @@ type RemoteConfig struct {
 	Metrics                              structure.MetricConfiguration `schema:"Настройка метрик"`
 	Journal                              rx.Config                     `schema:"Настройка логирования"`
 	JournalingMethodsPatterns            []string                      `schema:"Список методов для логирования,список строк вида: 'module/group/method'(* - для частичного совпадения). При обработке запроса, если вызываемый метод совпадает со строкой из списка, тела запроса и ответа записываются в лог"`
+	ClientAddress                        ClientAddressConfig           `schema:"Определение адреса клиента,настройка доверенных прокси и PROXY protocol"`
+	Tls                                  TlsConfig                     `schema:"Настройка TLS,терминирование TLS на HTTP порту"`
+	RouterBalancer                       BalancerConfig                `schema:"Балансировка маршрутизаторов,алгоритм распределения вызовов между экземплярами сервиса router"`
+	RouterTransport                      GrpcTransportConfig           `schema:"Защита соединения с маршрутизатором,настройка TLS/mTLS для соединений с сервисом router"`
+	RouterClusters                       []RouterClusterConfig         `schema:"Кластеры маршрутизаторов,отдельные пулы маршрутизаторов для групп методов, применяется первый кластер, подходящий по методу. Остальные методы вызываются через сервис router из конфигурации"`
+	RouterFailover                       FailoverConfig                `schema:"Переключение на резервный кластер,при недоступности всех маршрутизаторов вызовы направляются в резервный кластер до восстановления основного"`
+	RouterMirrors                        []MirrorConfig                `schema:"Зеркалирование трафика,копии вызовов асинхронно отправляются в теневой кластер маршрутизаторов, ответы теневого кластера отбрасываются. Не применяется к передаче файлов"`
+	RouterSplits                         []TrafficSplitConfig          `schema:"Разделение трафика,распределение вызовов между основным и canary кластером маршрутизаторов, применяется первое правило, подходящее по методу"`
+	JournalTransport                     GrpcTransportConfig           `schema:"Защита соединения с журналом,настройка TLS/mTLS для соединений с сервисом journal"`
+	Cors                                 CorsConfig                    `schema:"Настройка CORS,обработка preflight запросов и заголовки Access-Control-* для вызовов из браузера с других доменов"`
+	Jwt                                  JwtConfig                     `schema:"Проверка JWT,проверка bearer токенов до вызова маршрутизатора"`
+	Hmac                                 HmacConfig                    `schema:"Проверка подписи запросов,HMAC подпись запросов от партнеров с защитой от повторов"`
+	ApiKeys                              ApiKeysConfig                 `schema:"API ключи,реестр ключей с правами на вызов методов"`
+	IpAccessRules                        []IpAccessRuleConfig          `schema:"Ограничение доступа по IP,правила проверяются по порядку для реального адреса клиента, применяется первое правило, подходящее по методу"`
+	RateLimits                           []RateLimitConfig             `schema:"Ограничение частоты запросов,token bucket на каждое правило, применяются все правила, подходящие по методу"`
+	Bulkheads                            []BulkheadConfig              `schema:"Ограничение параллельных вызовов,максимальное количество одновременных вызовов по методам, применяется первое правило, подходящее по методу"`
+	AdaptiveLimit                        AdaptiveLimitConfig           `schema:"Адаптивное ограничение вызовов маршрутизатора,лимит одновременных вызовов подстраивается по времени ответа маршрутизатора, лишние вызовы отклоняются с 503. Загрузка и выгрузка файлов не ограничивается"`
+	Priority                             PriorityConfig                `schema:"Приоритеты запросов,при перегрузке сначала отклоняются запросы с низким приоритетом"`
+	CircuitBreaker                       CircuitBreakerConfig          `schema:"Автоматический выключатель,при частых ошибках вызовы метода отклоняются сразу без обращения к маршрутизатору. Не применяется к загрузке и выгрузке файлов"`
+	Retries                              RetriesConfig                 `schema:"Повторные вызовы,повтор вызовов маршрутизатора по методам при временных ошибках, не применяется к загрузке файлов"`
+	Hedging                              []HedgePolicyConfig           `schema:"Дублирующие запросы,если маршрутизатор не ответил за заданный перцентиль времени ответа метода, отправляется второй запрос и используется первый успешный ответ. Второй запрос направляется на другой экземпляр маршрутизатора. Только для методов чтения"`
+	Quotas                               QuotasConfig                  `schema:"Квоты приложений,суточные и месячные лимиты вызовов по тарифным планам, счетчики сохраняются на диск"`
+	Metering                             MeteringConfig                `schema:"Учет использования,количество вызовов, ошибок, объем трафика и время ответа по приложениям и методам"`
+	Admin                                AdminConfig                   `schema:"Административный интерфейс,отдельный HTTP порт для служебных запросов"`
+}
+
+type ClientAddressConfig struct {
+	TrustedProxies      []string `schema:"Доверенные прокси,список подсетей в формате CIDR или отдельных адресов. Заголовки X-Forwarded-For, X-Real-Ip, X-Forwarded-Proto, X-Forwarded-Host и заголовок PROXY protocol принимаются только от них"`
+	EnableProxyProtocol bool     `schema:"PROXY protocol,включение/отключение разбора заголовка PROXY protocol v1/v2 на соединениях от доверенных прокси, по умолчанию отключено"`
 }
 
 func (cfg RemoteConfig) GetSyncInvokeTimeout() time.Duration {
@@ -57,3 +146,621 @@ func (cfg RemoteConfig) GetMaxRequestBodySize() int64 {
 	}
 	return cfg.MaxRequestBodySizeBytes
 }
+
+type TlsConfig struct {
+	Enable                    bool                              `schema:"Включение TLS,по умолчанию отключено"`
+	CertFile                  string                            `schema:"Сертификат,путь к файлу сертификата в формате PEM, может содержать цепочку"`
+	KeyFile                   string                            `schema:"Приватный ключ,путь к файлу ключа в формате PEM"`
+	MinVersion                string                            `schema:"Минимальная версия TLS,одно из значений: 1.0, 1.1, 1.2, 1.3, по умолчанию 1.2"`
+	CipherSuites              []string                          `schema:"Наборы шифров,список названий по IANA, например TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. По умолчанию используются наборы Go"`
+	Sni                       map[string]CertificateFilesConfig `schema:"Сертификаты по SNI,ключ - имя сервера (допускается '*.example.com'), значение - сертификат и ключ. Если имя не найдено, используется основной сертификат"`
+	CertificateReloadPeriodMs int64                             `schema:"Период проверки файлов,значение в миллисекундах, по умолчанию: 30000. При изменении файлов сертификаты перечитываются без перезапуска сервера"`
+	ClientAuth                ClientAuthConfig                  `schema:"Аутентификация клиентов по сертификатам (mTLS)"`
+}
+
+type ClientAuthConfig struct {
+	Enable                  bool     `schema:"Включение mTLS,по умолчанию отключено. Субъект, SAN и отпечаток сертификата клиента передаются в метаданных x-client-cert-subject, x-client-cert-san, x-client-cert-fingerprint"`
+	CaFiles                 []string `schema:"Доверенные центры сертификации,список путей к файлам в формате PEM, которыми проверяются сертификаты клиентов"`
+	RequiredMethodsPatterns []string `schema:"Методы, требующие сертификат,список строк вида: 'module/group/method'(* - для частичного совпадения). Если список пуст, сертификат требуется на этапе TLS рукопожатия для всех запросов, иначе сертификат проверяется, если предъявлен, и обязателен только для указанных методов"`
+}
+
+type CertificateFilesConfig struct {
+	CertFile string `schema:"Сертификат,путь к файлу сертификата в формате PEM"`
+	KeyFile  string `schema:"Приватный ключ,путь к файлу ключа в формате PEM"`
+}
+
+func (cfg TlsConfig) GetCertificateReloadPeriod() time.Duration {
+	if cfg.CertificateReloadPeriodMs <= 0 {
+		return defaultCertificateReloadPeriod
+	}
+	return time.Duration(cfg.CertificateReloadPeriodMs) * time.Millisecond
+}
+
+type GrpcTransportConfig struct {
+	EnableTls                 bool     `schema:"Включение TLS,по умолчанию отключено. Переключение режима приводит к переподключению"`
+	CaFiles                   []string `schema:"Доверенные центры сертификации,список путей к файлам в формате PEM, по умолчанию используются системные"`
+	CertFile                  string   `schema:"Сертификат клиента,путь к файлу в формате PEM для mTLS"`
+	KeyFile                   string   `schema:"Ключ клиента,путь к файлу в формате PEM для mTLS"`
+	ServerNameOverride        string   `schema:"Имя сервера,переопределение имени, с которым сверяется сертификат сервера, по умолчанию используется адрес"`
+	MinVersion                string   `schema:"Минимальная версия TLS,одно из значений: 1.0, 1.1, 1.2, 1.3, по умолчанию 1.2"`
+	CertificateReloadPeriodMs int64    `schema:"Период проверки файлов,значение в миллисекундах, по умолчанию: 30000. Обновленные сертификаты используются для новых соединений, установленные соединения не разрываются"`
+}
+
+func (cfg GrpcTransportConfig) GetCertificateReloadPeriod() time.Duration {
+	if cfg.CertificateReloadPeriodMs <= 0 {
+		return defaultCertificateReloadPeriod
+	}
+	return time.Duration(cfg.CertificateReloadPeriodMs) * time.Millisecond
+}
+
+type BalancerConfig struct {
+	Algorithm        string                 `schema:"Алгоритм,round_robin, weighted, least_outstanding, power_of_two или consistent_hash, по умолчанию round_robin"`
+	Weights          map[string]int         `schema:"Веса экземпляров,адрес в виде ip:port и вес, по умолчанию 1. Используются алгоритмами weighted и consistent_hash"`
+	HashHeader       string                 `schema:"Заголовок для consistent_hash,имя метаданных запроса, например x-tenant-id или x-client-ip. Запросы без значения распределяются по кругу"`
+	StickyHeader     string                 `schema:"Заголовок закрепления,имя метаданных запроса, например x-session-id. Запросы с одинаковым значением направляются на один экземпляр независимо от алгоритма, при его недоступности - на следующий по кольцу"`
+	VirtualNodes     int                    `schema:"Количество точек экземпляра на кольце consistent_hash,на единицу веса, по умолчанию 100"`
+	HealthCheck      HealthCheckConfig      `schema:"Проверка состояния,опрос экземпляров по протоколу grpc.health.v1"`
+	OutlierDetection OutlierDetectionConfig `schema:"Исключение сбойных экземпляров,временное исключение по ошибкам Unavailable и DeadlineExceeded в ответах на вызовы"`
+}
+
+type RouterClusterConfig struct {
+	Name                        string                           `schema:"Название кластера,используется в логах и метриках"`
+	MethodsPatterns             []string                         `schema:"Список методов,список строк вида: 'module/group/method'(* - для частичного совпадения). Кластер без методов используется только как резервный"`
+	StandbyCluster              string                           `schema:"Резервный кластер,название кластера, в который направляются вызовы при недоступности всех маршрутизаторов этого кластера"`
+	Addresses                   []structure.AddressConfiguration `schema:"Адреса маршрутизаторов"`
+	Transport                   GrpcTransportConfig              `schema:"Защита соединения,настройка TLS/mTLS для соединений с маршрутизаторами кластера"`
+	Dial                        DialConfig                       `schema:"Параметры соединения"`
+	Balancer                    BalancerConfig                   `schema:"Балансировка,алгоритм распределения вызовов между маршрутизаторами кластера"`
+	SyncInvokeMethodTimeoutMs   int64                            `schema:"Время ожидания вызова метода,значение в миллисекундах, по умолчанию используется общее значение"`
+	StreamInvokeMethodTimeoutMs int64                            `schema:"Время ожидания передачи и обработки файла,значение в миллисекундах, по умолчанию используется общее значение"`
+}
+
+type FailoverConfig struct {
+	StandbyCluster string `schema:"Резервный кластер для сервиса router,название кластера из списка кластеров маршрутизаторов"`
+	Header         string `schema:"Заголовок ответа,в заголовке передается название резервного кластера, обработавшего запрос, по умолчанию X-Router-Failover"`
+}
+
+func (cfg FailoverConfig) GetHeader() string {
+	if cfg.Header == "" {
+		return defaultFailoverHeader
+	}
+	return cfg.Header
+}
+
+type DialConfig struct {
+	MaxMessageSizeBytes int64 `schema:"Максимальный размер сообщения,в байтах, по умолчанию: 32 MB"`
+	KeepaliveTimeMs     int64 `schema:"Период keepalive пингов,значение в миллисекундах, по умолчанию отключено"`
+	KeepaliveTimeoutMs  int64 `schema:"Время ожидания ответа на keepalive пинг,значение в миллисекундах, по умолчанию: 20000"`
+}
+
+func (cfg DialConfig) GetMaxMessageSize() int64 {
+	if cfg.MaxMessageSizeBytes <= 0 {
+		return DefaultMaxResponseBodySize
+	}
+	return cfg.MaxMessageSizeBytes
+}
+
+func (cfg DialConfig) GetKeepaliveTimeout() time.Duration {
+	if cfg.KeepaliveTimeoutMs <= 0 {
+		return defaultKeepaliveTimeout
+	}
+	return time.Duration(cfg.KeepaliveTimeoutMs) * time.Millisecond
+}
+
+type HealthCheckConfig struct {
+	Enable             bool   `schema:"Включить"`
+	Service            string `schema:"Имя сервиса,передается в запросе проверки, по умолчанию пустое - состояние сервера в целом"`
+	IntervalMs         int64  `schema:"Период проверки,значение в миллисекундах, по умолчанию: 5000"`
+	TimeoutMs          int64  `schema:"Время ожидания ответа,значение в миллисекундах, по умолчанию: 1000"`
+	UnhealthyThreshold int    `schema:"Неудачных проверок подряд,для исключения экземпляра, по умолчанию 2"`
+	HealthyThreshold   int    `schema:"Успешных проверок подряд,для возврата экземпляра, по умолчанию 1"`
+}
+
+func (cfg HealthCheckConfig) GetInterval() time.Duration {
+	if cfg.IntervalMs <= 0 {
+		return defaultHealthCheckInterval
+	}
+	return time.Duration(cfg.IntervalMs) * time.Millisecond
+}
+
+func (cfg HealthCheckConfig) GetTimeout() time.Duration {
+	if cfg.TimeoutMs <= 0 {
+		return defaultHealthCheckTimeout
+	}
+	return time.Duration(cfg.TimeoutMs) * time.Millisecond
+}
+
+func (cfg HealthCheckConfig) GetUnhealthyThreshold() int {
+	if cfg.UnhealthyThreshold <= 0 {
+		return defaultUnhealthyThreshold
+	}
+	return cfg.UnhealthyThreshold
+}
+
+func (cfg HealthCheckConfig) GetHealthyThreshold() int {
+	if cfg.HealthyThreshold <= 0 {
+		return defaultHealthyThreshold
+	}
+	return cfg.HealthyThreshold
+}
+
+type OutlierDetectionConfig struct {
+	Enable              bool  `schema:"Включить"`
+	ConsecutiveFailures int   `schema:"Ошибок подряд,для немедленного исключения, 0 - не учитывается"`
+	FailurePercent      int   `schema:"Доля ошибок,процент ошибок за период, 0 - не учитывается"`
+	MinRequests         int   `schema:"Минимум вызовов за период,для расчета доли ошибок, по умолчанию 20"`
+	IntervalMs          int64 `schema:"Период расчета доли ошибок,значение в миллисекундах, по умолчанию: 10000"`
+	BaseEjectionTimeMs  int64 `schema:"Время исключения,умножается на количество исключений подряд, значение в миллисекундах, по умолчанию: 30000"`
+	MaxEjectionTimeMs   int64 `schema:"Максимальное время исключения,значение в миллисекундах, по умолчанию: 300000"`
+	MaxEjectedPercent   int   `schema:"Максимальная доля исключенных экземпляров,в процентах, по умолчанию 50. Ограничивает только исключение по ошибкам вызовов, экземпляры без соединения или не прошедшие проверку состояния недоступны независимо от него. Чтобы ошибки вызовов всех экземпляров переключали кластер на резервный, укажите 100"`
+}
+
+func (cfg OutlierDetectionConfig) GetInterval() time.Duration {
+	if cfg.IntervalMs <= 0 {
+		return defaultOutlierInterval
+	}
+	return time.Duration(cfg.IntervalMs) * time.Millisecond
+}
+
+func (cfg OutlierDetectionConfig) GetMinRequests() int {
+	if cfg.MinRequests <= 0 {
+		return defaultOutlierMinRequests
+	}
+	return cfg.MinRequests
+}
+
+func (cfg OutlierDetectionConfig) GetBaseEjectionTime() time.Duration {
+	if cfg.BaseEjectionTimeMs <= 0 {
+		return defaultOutlierBaseEjectionTime
+	}
+	return time.Duration(cfg.BaseEjectionTimeMs) * time.Millisecond
+}
+
+func (cfg OutlierDetectionConfig) GetMaxEjectionTime() time.Duration {
+	if cfg.MaxEjectionTimeMs <= 0 {
+		return defaultOutlierMaxEjectionTime
+	}
+	return time.Duration(cfg.MaxEjectionTimeMs) * time.Millisecond
+}
+
+func (cfg OutlierDetectionConfig) GetMaxEjectedPercent() int {
+	if cfg.MaxEjectedPercent <= 0 {
+		return defaultOutlierMaxEjectedPercent
+	}
+	return cfg.MaxEjectedPercent
+}
+
+type CorsConfig struct {
+	Enable           bool     `schema:"Включение CORS,по умолчанию отключено"`
+	AllowedOrigins   []string `schema:"Разрешенные источники,список вида 'https://app.example.com', допускается '*' для любого источника и 'https://*.example.com' для поддоменов"`
+	AllowedMethods   []string `schema:"Разрешенные методы HTTP,по умолчанию GET и POST"`
+	AllowedHeaders   []string `schema:"Разрешенные заголовки,список заголовков запроса. Если список пуст или содержит '*', разрешаются любые запрошенные заголовки"`
+	ExposedHeaders   []string `schema:"Доступные заголовки ответа,список заголовков, которые браузер разрешит прочитать клиентскому коду"`
+	AllowCredentials bool     `schema:"Передача учетных данных,разрешение cookies и заголовка Authorization, по умолчанию отключено, не допускается вместе с источником '*'"`
+	MaxAgeSeconds    int      `schema:"Время кэширования preflight,значение в секундах, по умолчанию не передается"`
+}
+
+type JwtConfig struct {
+	Enable              bool              `schema:"Включение проверки JWT,по умолчанию отключено. Запросы с отсутствующим или невалидным токеном отклоняются с кодом Unauthenticated"`
+	MethodsPatterns     []string          `schema:"Методы, требующие токен,список строк вида: 'module/group/method'(* - для частичного совпадения). Если список пуст, токен требуется для всех методов"`
+	Header              string            `schema:"Заголовок с токеном,по умолчанию Authorization, префикс 'Bearer ' необязателен"`
+	JwksFile            string            `schema:"Файл JWKS,путь к файлу с публичными ключами, имеет приоритет над адресом"`
+	JwksUrl             string            `schema:"Адрес JWKS,URL, по которому загружаются публичные ключи"`
+	JwksRefreshPeriodMs int64             `schema:"Период обновления ключей,значение в миллисекундах, по умолчанию: 300000. Ключи также обновляются при получении токена с неизвестным kid"`
+	Issuers             []string          `schema:"Допустимые издатели,значения claim iss, если список пуст - не проверяется"`
+	Audiences           []string          `schema:"Допустимые получатели,значения claim aud, если список пуст - не проверяется"`
+	ClockSkewMs         int64             `schema:"Допустимое расхождение часов,значение в миллисекундах, по умолчанию: 60000"`
+	ClaimsMetadata      map[string]string `schema:"Передача claims в метаданных,ключ - название claim, значение - ключ метаданных, например 'sub': 'x-user-id'. Одноименные заголовки от клиента не передаются"`
+}
+
+func (cfg JwtConfig) GetHeader() string {
+	if cfg.Header == "" {
+		return defaultJwtHeader
+	}
+	return cfg.Header
+}
+
+func (cfg JwtConfig) GetJwksRefreshPeriod() time.Duration {
+	if cfg.JwksRefreshPeriodMs <= 0 {
+		return defaultJwksRefresh
+	}
+	return time.Duration(cfg.JwksRefreshPeriodMs) * time.Millisecond
+}
+
+func (cfg JwtConfig) GetClockSkew() time.Duration {
+	if cfg.ClockSkewMs <= 0 {
+		return defaultJwtClockSkew
+	}
+	return time.Duration(cfg.ClockSkewMs) * time.Millisecond
+}
+
+type HmacConfig struct {
+	Enable          bool              `schema:"Включение проверки подписи,по умолчанию отключено"`
+	MethodsPatterns []string          `schema:"Методы, требующие подпись,список строк вида: 'module/group/method'(* - для частичного совпадения)"`
+	Keys            map[string]string `schema:"Ключи,ключ - идентификатор, передаваемый в заголовке X-Signature-Key-Id, значение - общий секрет"`
+	Algorithm       string            `schema:"Алгоритм,sha256 или sha512, по умолчанию sha256. Подписывается строка из HTTP метода, URI запроса, значения X-Signature-Timestamp (unix время в секундах), X-Signature-Nonce и hex sha256 тела, разделенных переводом строки. Подпись передается в hex в заголовке X-Signature"`
+	ClockSkewMs     int64             `schema:"Допустимое расхождение времени,значение в миллисекундах, по умолчанию: 300000. Запросы с временем подписи вне окна отклоняются, nonce запоминаются на время окна"`
+}
+
+func (cfg HmacConfig) GetClockSkew() time.Duration {
+	if cfg.ClockSkewMs <= 0 {
+		return defaultHmacClockSkew
+	}
+	return time.Duration(cfg.ClockSkewMs) * time.Millisecond
+}
+
+type ApiKeysConfig struct {
+	Enable          bool           `schema:"Включение проверки API ключей,по умолчанию отключено. Владелец ключа передается в метаданных x-api-key-owner, сам ключ не передается"`
+	MethodsPatterns []string       `schema:"Методы, требующие ключ,список строк вида: 'module/group/method'(* - для частичного совпадения). Если список пуст, ключ требуется для всех методов"`
+	Header          string         `schema:"Заголовок с ключом,по умолчанию X-Api-Key"`
+	File            string         `schema:"Файл с ключами,путь к локальному JSON файлу со списком ключей в том же формате, что и Keys. Ключи из удаленной конфигурации имеют приоритет"`
+	Keys            []ApiKeyConfig `schema:"Ключи"`
+}
+
+type ApiKeyConfig struct {
+	Key                    string   `schema:"Значение ключа"`
+	Owner                  string   `schema:"Владелец"`
+	AllowedMethodsPatterns []string `schema:"Разрешенные методы,список строк вида: 'module/group/method'(* - для частичного совпадения)"`
+	ExpiresAt              string   `schema:"Срок действия,время в формате RFC3339, если не задано - бессрочно"`
+	Enabled                bool     `schema:"Ключ активен"`
+}
+
+func (cfg ApiKeysConfig) GetHeader() string {
+	if cfg.Header == "" {
+		return defaultApiKeyHeader
+	}
+	return cfg.Header
+}
+
+type IpAccessRuleConfig struct {
+	MethodsPatterns []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения)"`
+	Allow           []string `schema:"Разрешенные адреса,список подсетей в формате CIDR или отдельных адресов. Если список не пуст, доступ разрешен только из них"`
+	Deny            []string `schema:"Запрещенные адреса,список подсетей в формате CIDR или отдельных адресов. Проверяется раньше списка разрешенных"`
+}
+
+type RateLimitConfig struct {
+	Name              string   `schema:"Название,используется в метриках отклоненных запросов, по умолчанию номер правила"`
+	MethodsPatterns   []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения). Если список пуст, правило применяется ко всем методам"`
+	PerMethod         bool     `schema:"Отдельный лимит на каждый метод,иначе лимит общий для всех подходящих методов"`
+	KeyHeader         string   `schema:"Заголовок ключа,отдельный лимит на каждое значение заголовка, например токен приложения. Запросы без заголовка правилом не ограничиваются"`
+	ByClientIp        bool     `schema:"Отдельный лимит на каждый адрес клиента"`
+	RequestsPerSecond float64  `schema:"Скорость пополнения,количество запросов в секунду"`
+	Burst             int      `schema:"Размер всплеска,максимальное количество запросов подряд, по умолчанию равен скорости пополнения"`
+}
+
+type BulkheadConfig struct {
+	Name            string   `schema:"Название,используется в сообщении об ошибке, по умолчанию номер правила"`
+	MethodsPatterns []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения)"`
+	MaxConcurrent   int      `schema:"Максимум одновременных вызовов"`
+	MaxQueue        int      `schema:"Размер очереди,количество вызовов, ожидающих освобождения слота, по умолчанию 0 - без ожидания"`
+	QueueTimeoutMs  int64    `schema:"Время ожидания в очереди,значение в миллисекундах, по умолчанию: 1000"`
+}
+
+func (cfg BulkheadConfig) GetQueueTimeout() time.Duration {
+	if cfg.QueueTimeoutMs <= 0 {
+		return defaultBulkheadQueueTimeout
+	}
+	return time.Duration(cfg.QueueTimeoutMs) * time.Millisecond
+}
+
+type AdaptiveLimitConfig struct {
+	Enable       bool    `schema:"Включить"`
+	InitialLimit int     `schema:"Начальный лимит,по умолчанию 20"`
+	MinLimit     int     `schema:"Минимальный лимит,по умолчанию 1"`
+	MaxLimit     int     `schema:"Максимальный лимит,по умолчанию 1000"`
+	Tolerance    float64 `schema:"Допустимый рост времени ответа,во сколько раз время ответа может превысить базовое без снижения лимита, по умолчанию 1.5"`
+}
+
+func (cfg AdaptiveLimitConfig) GetInitialLimit() int {
+	if cfg.InitialLimit <= 0 {
+		return defaultAdaptiveInitialLimit
+	}
+	return cfg.InitialLimit
+}
+
+func (cfg AdaptiveLimitConfig) GetMinLimit() int {
+	if cfg.MinLimit <= 0 {
+		return defaultAdaptiveMinLimit
+	}
+	return cfg.MinLimit
+}
+
+func (cfg AdaptiveLimitConfig) GetMaxLimit() int {
+	if cfg.MaxLimit <= 0 {
+		return defaultAdaptiveMaxLimit
+	}
+	return cfg.MaxLimit
+}
+
+func (cfg AdaptiveLimitConfig) GetTolerance() float64 {
+	if cfg.Tolerance < 1 {
+		return defaultAdaptiveTolerance
+	}
+	return cfg.Tolerance
+}
+
+type PriorityConfig struct {
+	Enable          bool                 `schema:"Включить"`
+	Header          string               `schema:"Заголовок приоритета,значение заголовка определяет уровень приоритета, учитывается только от доверенных прокси"`
+	Tiers           []PriorityTierConfig `schema:"Уровни приоритета,уровень определяется по заголовку, затем по методу в порядке списка"`
+	DefaultTier     string               `schema:"Уровень по умолчанию,название уровня для запросов, не подходящих ни под один уровень. Если не задан, такие запросы не отклоняются"`
+	MaxInflight     int                  `schema:"Максимум одновременных вызовов,нагрузка считается как доля от этого значения"`
+	MaxQueueDelayMs int64                `schema:"Максимальное время ожидания в очереди,значение в миллисекундах, нагрузка считается как доля среднего времени ожидания ограничений параллельных вызовов от этого значения, без новых замеров среднее вдвое убывает каждую секунду"`
+}
+
+type PriorityTierConfig struct {
+	Name            string   `schema:"Название"`
+	MethodsPatterns []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения)"`
+	HeaderValues    []string `schema:"Значения заголовка приоритета"`
+	ShedAtPercent   int      `schema:"Порог отклонения,нагрузка в процентах, начиная с которой запросы уровня отклоняются, по умолчанию 100"`
+}
+
+func (cfg PriorityTierConfig) GetShedAtPercent() int {
+	if cfg.ShedAtPercent <= 0 {
+		return defaultPriorityShedAtPercent
+	}
+	return cfg.ShedAtPercent
+}
+
+type CircuitBreakerConfig struct {
+	Enable              bool     `schema:"Включить"`
+	MethodsPatterns     []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения). Если список пуст, применяется ко всем методам"`
+	ConsecutiveFailures int      `schema:"Ошибок подряд,количество ошибок Unavailable, DeadlineExceeded или Internal подряд, после которого выключатель размыкается, 0 - не учитывается"`
+	ErrorRatePercent    int      `schema:"Доля ошибок,процент ошибок за окно, после которого выключатель размыкается, 0 - не учитывается"`
+	MinRequests         int      `schema:"Минимум вызовов в окне,для расчета доли ошибок, по умолчанию 20"`
+	WindowMs            int64    `schema:"Окно расчета доли ошибок,значение в миллисекундах, по умолчанию: 10000"`
+	OpenTimeoutMs       int64    `schema:"Время в разомкнутом состоянии,значение в миллисекундах, после которого пропускаются пробные вызовы, по умолчанию: 30000"`
+	HalfOpenProbes      int      `schema:"Количество пробных вызовов,выключатель замыкается, если все пробные вызовы успешны, по умолчанию 1"`
+}
+
+func (cfg CircuitBreakerConfig) GetMinRequests() int {
+	if cfg.MinRequests <= 0 {
+		return defaultBreakerMinRequests
+	}
+	return cfg.MinRequests
+}
+
+func (cfg CircuitBreakerConfig) GetWindow() time.Duration {
+	if cfg.WindowMs <= 0 {
+		return defaultBreakerWindow
+	}
+	return time.Duration(cfg.WindowMs) * time.Millisecond
+}
+
+func (cfg CircuitBreakerConfig) GetOpenTimeout() time.Duration {
+	if cfg.OpenTimeoutMs <= 0 {
+		return defaultBreakerOpenTimeout
+	}
+	return time.Duration(cfg.OpenTimeoutMs) * time.Millisecond
+}
+
+func (cfg CircuitBreakerConfig) GetHalfOpenProbes() int {
+	if cfg.HalfOpenProbes <= 0 {
+		return defaultBreakerHalfOpenProbes
+	}
+	return cfg.HalfOpenProbes
+}
+
+type RetriesConfig struct {
+	Policies            []RetryPolicyConfig `schema:"Политики,применяется первая политика, подходящая по методу"`
+	BudgetPercent       int                 `schema:"Бюджет повторов,максимальная доля повторов от всех вызовов за последние 10 секунд в процентах, по умолчанию 20"`
+	MinRetriesPerSecond int                 `schema:"Минимум повторов в секунду,разрешается независимо от доли, по умолчанию 10"`
+}
+
+type RetryPolicyConfig struct {
+	MethodsPatterns   []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения). Повторять можно только идемпотентные методы"`
+	Codes             []string `schema:"Коды ошибок,коды GRPC, при которых вызов повторяется, по умолчанию UNAVAILABLE"`
+	MaxAttempts       int      `schema:"Максимум попыток,включая первый вызов, по умолчанию 3"`
+	InitialBackoffMs  int64    `schema:"Начальная пауза,значение в миллисекундах, по умолчанию: 50"`
+	MaxBackoffMs      int64    `schema:"Максимальная пауза,значение в миллисекундах, по умолчанию: 1000"`
+	BackoffMultiplier float64  `schema:"Множитель паузы,по умолчанию 2"`
+}
+
+func (cfg RetriesConfig) GetBudgetPercent() int {
+	if cfg.BudgetPercent <= 0 {
+		return defaultRetryBudgetPercent
+	}
+	return cfg.BudgetPercent
+}
+
+func (cfg RetriesConfig) GetMinRetriesPerSecond() int {
+	if cfg.MinRetriesPerSecond <= 0 {
+		return defaultRetryMinPerSecond
+	}
+	return cfg.MinRetriesPerSecond
+}
+
+func (cfg RetryPolicyConfig) GetCodes() []string {
+	if len(cfg.Codes) == 0 {
+		return []string{"UNAVAILABLE"}
+	}
+	return cfg.Codes
+}
+
+func (cfg RetryPolicyConfig) GetMaxAttempts() int {
+	if cfg.MaxAttempts <= 0 {
+		return defaultRetryMaxAttempts
+	}
+	return cfg.MaxAttempts
+}
+
+func (cfg RetryPolicyConfig) GetInitialBackoff() time.Duration {
+	if cfg.InitialBackoffMs <= 0 {
+		return defaultRetryInitialBackoff
+	}
+	return time.Duration(cfg.InitialBackoffMs) * time.Millisecond
+}
+
+func (cfg RetryPolicyConfig) GetMaxBackoff() time.Duration {
+	if cfg.MaxBackoffMs <= 0 {
+		return defaultRetryMaxBackoff
+	}
+	return time.Duration(cfg.MaxBackoffMs) * time.Millisecond
+}
+
+func (cfg RetryPolicyConfig) GetBackoffMultiplier() float64 {
+	if cfg.BackoffMultiplier < 1 {
+		return defaultRetryBackoffMultiplier
+	}
+	return cfg.BackoffMultiplier
+}
+
+type MirrorConfig struct {
+	Name             string   `schema:"Название,используется в логах и метриках"`
+	MethodsPatterns  []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения), применяются все правила, подходящие по методу"`
+	Cluster          string   `schema:"Теневой кластер,название кластера маршрутизаторов без своих методов, не используемого как резервный или в разделении трафика. Теневые вызовы не переключаются на резервный кластер"`
+	SamplePercent    float64  `schema:"Доля запросов,процент копируемых запросов, по умолчанию 100"`
+	TimeoutMs        int64    `schema:"Время ожидания теневого вызова,значение в миллисекундах, по умолчанию: 5000"`
+	MaxConcurrent    int      `schema:"Максимум одновременных теневых вызовов,копии сверх лимита не отправляются, по умолчанию 100"`
+	CompareResponses bool     `schema:"Сравнение ответов,расхождения ответов основного и теневого кластера записываются в лог"`
+}
+
+func (cfg MirrorConfig) GetSamplePercent() float64 {
+	if cfg.SamplePercent <= 0 || cfg.SamplePercent > 100 {
+		return defaultMirrorSamplePercent
+	}
+	return cfg.SamplePercent
+}
+
+func (cfg MirrorConfig) GetTimeout() time.Duration {
+	if cfg.TimeoutMs <= 0 {
+		return defaultMirrorTimeout
+	}
+	return time.Duration(cfg.TimeoutMs) * time.Millisecond
+}
+
+func (cfg MirrorConfig) GetMaxConcurrent() int {
+	if cfg.MaxConcurrent <= 0 {
+		return defaultMirrorMaxConcurrent
+	}
+	return cfg.MaxConcurrent
+}
+
+type TrafficSplitConfig struct {
+	Name            string   `schema:"Название,используется в метриках"`
+	MethodsPatterns []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения)"`
+	PrimaryCluster  string   `schema:"Основной кластер,название кластера маршрутизаторов, router - сервис router из конфигурации"`
+	CanaryCluster   string   `schema:"Canary кластер,название кластера маршрутизаторов, router - сервис router из конфигурации"`
+	Mode            string   `schema:"Способ выбора,percent - случайная доля запросов, header или cookie - по значению заголовка или cookie, user_hash - доля пользователей по хешу значения заголовка. По умолчанию percent"`
+	Key             string   `schema:"Имя заголовка или cookie,для способов header, cookie и user_hash"`
+	Value           string   `schema:"Значение для canary,для способов header и cookie, если не задано - любое непустое значение"`
+	Percent         int      `schema:"Доля canary,процент запросов или пользователей для способов percent и user_hash"`
+}
+
+type HedgePolicyConfig struct {
+	MethodsPatterns    []string `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения), применяется первая политика, подходящая по методу"`
+	Percentile         float64  `schema:"Перцентиль времени ответа,задержка второго запроса, считается по успешным вызовам метода маршрутизатором, по умолчанию 95"`
+	DelayMs            int64    `schema:"Задержка до накопления статистики,значение в миллисекундах, по умолчанию: 100"`
+	MinDelayMs         int64    `schema:"Минимальная задержка,значение в миллисекундах"`
+	BudgetPercent      int      `schema:"Бюджет дублирующих запросов,максимальная доля дублирующих запросов от всех вызовов за последние 10 секунд в процентах, по умолчанию 10"`
+	MinHedgesPerSecond int      `schema:"Минимум дублирующих запросов в секунду,разрешается независимо от доли, по умолчанию 1"`
+}
+
+func (cfg HedgePolicyConfig) GetPercentile() float64 {
+	if cfg.Percentile <= 0 || cfg.Percentile >= 100 {
+		return defaultHedgePercentile
+	}
+	return cfg.Percentile
+}
+
+func (cfg HedgePolicyConfig) GetDelay() time.Duration {
+	if cfg.DelayMs <= 0 {
+		return defaultHedgeDelay
+	}
+	return time.Duration(cfg.DelayMs) * time.Millisecond
+}
+
+func (cfg HedgePolicyConfig) GetBudgetPercent() int {
+	if cfg.BudgetPercent <= 0 {
+		return defaultHedgeBudgetPercent
+	}
+	return cfg.BudgetPercent
+}
+
+func (cfg HedgePolicyConfig) GetMinHedgesPerSecond() int {
+	if cfg.MinHedgesPerSecond <= 0 {
+		return defaultHedgeMinPerSecond
+	}
+	return cfg.MinHedgesPerSecond
+}
+
+type QuotasConfig struct {
+	Enable          bool              `schema:"Включить"`
+	MethodsPatterns []string          `schema:"Методы,список строк вида: 'module/group/method'(* - для частичного совпадения). Если список пуст, учитываются все методы"`
+	Header          string            `schema:"Заголовок приложения,по умолчанию X-Application-Token"`
+	Plans           []QuotaPlanConfig `schema:"Тарифные планы"`
+	DefaultPlan     string            `schema:"План по умолчанию,название плана для приложений, не указанных ни в одном плане. Если не задан, такие приложения не ограничиваются"`
+	Timezone        string            `schema:"Часовой пояс,используется для определения границ суток и месяца, например Europe/Moscow, по умолчанию UTC"`
+	StoreFile       string            `schema:"Файл счетчиков,по умолчанию /var/lib/isp-convert-service/quotas.json"`
+	FlushPeriodMs   int64             `schema:"Период сохранения счетчиков,значение в миллисекундах, по умолчанию: 5000"`
+}
+
+type QuotaPlanConfig struct {
+	Name         string   `schema:"Название"`
+	Applications []string `schema:"Приложения,значения заголовка приложения"`
+	DailyLimit   int64    `schema:"Суточный лимит,0 - без ограничения"`
+	MonthlyLimit int64    `schema:"Месячный лимит,0 - без ограничения"`
+}
+
+func (cfg QuotasConfig) GetHeader() string {
+	if cfg.Header == "" {
+		return defaultQuotaHeader
+	}
+	return cfg.Header
+}
+
+func (cfg QuotasConfig) GetStoreFile() string {
+	if cfg.StoreFile == "" {
+		return defaultQuotaStoreFile
+	}
+	return cfg.StoreFile
+}
+
+func (cfg QuotasConfig) GetFlushPeriod() time.Duration {
+	if cfg.FlushPeriodMs <= 0 {
+		return defaultQuotaFlushPeriod
+	}
+	return time.Duration(cfg.FlushPeriodMs) * time.Millisecond
+}
+
+type MeteringConfig struct {
+	Enable        bool   `schema:"Включить"`
+	Header        string `schema:"Заголовок приложения,по умолчанию X-Application-Token"`
+	Directory     string `schema:"Каталог выгрузки,файлы usage-<дата>.<формат>, по умолчанию /var/log/isp-convert-service/usage"`
+	Format        string `schema:"Формат выгрузки,csv или jsonl, по умолчанию csv"`
+	FlushPeriodMs int64  `schema:"Период выгрузки,значение в миллисекундах, по умолчанию: 60000"`
+}
+
+func (cfg MeteringConfig) GetHeader() string {
+	if cfg.Header == "" {
+		return defaultQuotaHeader
+	}
+	return cfg.Header
+}
+
+func (cfg MeteringConfig) GetDirectory() string {
+	if cfg.Directory == "" {
+		return defaultMeteringDirectory
+	}
+	return cfg.Directory
+}
+
+func (cfg MeteringConfig) GetFormat() string {
+	if cfg.Format == "" {
+		return defaultMeteringFormat
+	}
+	return cfg.Format
+}
+
+func (cfg MeteringConfig) GetFlushPeriod() time.Duration {
+	if cfg.FlushPeriodMs <= 0 {
+		return defaultMeteringFlushPeriod
+	}
+	return time.Duration(cfg.FlushPeriodMs) * time.Millisecond
+}
+
+type AdminConfig struct {
+	Enable  bool                           `schema:"Включить"`
+	Address structure.AddressConfiguration `schema:"Адрес"`
+	Token   string                         `schema:"Токен доступа,обязателен, запросы должны содержать заголовок Authorization: Bearer <токен>"`
+}
@@ -5,9 +5,24 @@ import (
 	"github.com/integration-system/isp-lib/proto/stubs"
 	log "github.com/integration-system/isp-log"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/acl"
+	"isp-convert-service/adaptive"
+	"isp-convert-service/auth"
+	"isp-convert-service/breaker"
+	"isp-convert-service/bulkhead"
+	"isp-convert-service/canary"
 	"isp-convert-service/conf"
+	"isp-convert-service/cors"
+	"isp-convert-service/hedge"
 	"isp-convert-service/journal"
 	"isp-convert-service/log_code"
+	"isp-convert-service/metering"
+	"isp-convert-service/mirror"
+	"isp-convert-service/priority"
+	"isp-convert-service/quota"
+	"isp-convert-service/ratelimit"
+	"isp-convert-service/retry"
 	"isp-convert-service/service"
 	"mime"
 	"net/http"
@@ -26,9 +41,24 @@ import (
 func HandlerAllRequest(ctx *fasthttp.RequestCtx) {
 	currentTime := time.Now()
 
+	cors.GetPolicy().Decorate(ctx)
+
 	uri := string(ctx.RequestURI())
-	proxyRequestHandle(ctx, uri)
+	method := utils.MethodKey(ctx)
+	if err := checkRequest(ctx, method); err != nil {
+		rejectRequest(ctx, err)
+	} else if release, err := admitRequest(ctx, method); err != nil {
+		rejectRequest(ctx, err)
+	} else {
+		canary.Choose(ctx, method)
+		func() {
+			defer release()
+			proxyRequestHandle(ctx, uri)
+		}()
+	}
 
+	metering.Record(ctx, method, time.Since(currentTime))
+	canary.Record(ctx, time.Since(currentTime))
 	executionTime := time.Since(currentTime) / 1e6
 	metrics := service.GetMetrics()
 	metrics.UpdateStatusCounter(ctx.Response.StatusCode())
@@ -38,6 +68,75 @@ func HandlerAllRequest(ctx *fasthttp.RequestCtx) {
 	}
 }
 
+func HandlePreflight(ctx *fasthttp.RequestCtx) {
+	cors.GetPolicy().HandlePreflight(ctx)
+}
+
+// checkRequest runs checks which must pass before anything is sent to the router
+func checkRequest(ctx *fasthttp.RequestCtx, method string) error {
+	if err := acl.Check(ctx, method); err != nil {
+		return err
+	}
+	if err := auth.VerifyClientCertificate(ctx, method); err != nil {
+		return err
+	}
+	if err := auth.VerifyJwt(ctx, method); err != nil {
+		return err
+	}
+	if err := auth.VerifyHmacSignature(ctx, method); err != nil {
+		return err
+	}
+	if err := auth.VerifyApiKey(ctx, method); err != nil {
+		return err
+	}
+	if err := ratelimit.Check(ctx, method); err != nil {
+		return err
+	}
+	return nil
+}
+
+// admitRequest takes slots of concurrency limits, the returned function releases all of them
+func admitRequest(ctx *fasthttp.RequestCtx, method string) (func(), error) {
+	releasePriority, err := priority.Admit(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	queuedAt := time.Now()
+	releaseBulkhead, err := bulkhead.Acquire(method)
+	priority.ObserveQueueDelay(time.Since(queuedAt))
+	if err != nil {
+		releasePriority()
+		return nil, err
+	}
+	// streams don't produce response time samples, so they are not limited adaptively
+	releaseAdaptive := func() {}
+	if !isStreaming(ctx) {
+		if releaseAdaptive, err = adaptive.Acquire(); err != nil {
+			releaseBulkhead()
+			releasePriority()
+			return nil, err
+		}
+	}
+	// quota is charged last, so calls rejected by concurrency limits don't consume it
+	if err := quota.Check(ctx, method); err != nil {
+		releaseAdaptive()
+		releaseBulkhead()
+		releasePriority()
+		return nil, err
+	}
+	return func() {
+		releaseAdaptive()
+		releaseBulkhead()
+		releasePriority()
+	}, nil
+}
+
+func rejectRequest(ctx *fasthttp.RequestCtx, err error) {
+	s, _ := status.FromError(err)
+	ctx.Response.Header.SetContentType(utils.JsonContentType)
+	utils.SendError(s.Message(), s.Code(), nil, ctx)
+}
+
 func handleJson(c *fasthttp.RequestCtx, method string) {
 	//body, err := utils.ReadJsonBody(c)
 	body := c.Request.Body()
@@ -47,33 +146,60 @@ func handleJson(c *fasthttp.RequestCtx, method string) {
 		return
 	}*/
 
-	md, methodName := utils.MakeMetadata(&c.Request.Header, method)
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
-	cfg := config.GetRemote().(*conf.RemoteConfig)
-	ctx, cancel := context.WithTimeout(ctx, cfg.GetSyncInvokeTimeout())
-	defer cancel()
-
-	client, err := utils.GetGrpcClient()
+	md, methodName := utils.MakeMetadata(c, method)
+	methodKey := utils.MethodKey(c)
+	client, route, err := utils.GetGrpcClient(c, methodKey)
 	if err != nil {
+		quota.Refund(c)
 		utils.LogRequestHandlerError(log_code.TypeData.MethodInvoke, methodName, err)
 		utils.SendError(streaming.ErrorMsgInternal, codes.Internal, []interface{}{err.Error()}, c)
 		return
 	}
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	cfg := config.GetRemote().(*conf.RemoteConfig)
+	ctx, cancel := context.WithTimeout(ctx, route.SyncInvokeTimeout(cfg.GetSyncInvokeTimeout()))
+	defer cancel()
 
-	//structBody := u.ConvertInterfaceToGrpcStruct(body)
-	currentTime := time.Now()
-	response, invokerErr := client.Request(
-		ctx,
-		&isp.Message{
-			Body: &isp.Message_BytesBody{BytesBody: body},
-		},
+	var (
+		response *isp.Message
+		lastErr  error
 	)
-	service.GetMetrics().UpdateRouterResponseTime(time.Since(currentTime) / 1e6)
+	message := &isp.Message{
+		Body: &isp.Message_BytesBody{BytesBody: body},
+	}
+	if hedge.Applies(methodKey) {
+		// the losing hedged call may outlive the handler, while fasthttp reuses the body buffer
+		message.Body = &isp.Message_BytesBody{BytesBody: append([]byte(nil), body...)}
+	}
+	invokerErr := retry.Do(ctx, methodKey, func(ctx context.Context, attempt int) error {
+		breakerDone, err := breaker.Allow(methodKey)
+		if err != nil {
+			// the breaker opened after previous attempts, the client gets the error of the last one
+			if attempt > 1 {
+				return retry.Permanent(lastErr)
+			}
+			quota.Refund(c)
+			return retry.Permanent(err)
+		}
+
+		//structBody := u.ConvertInterfaceToGrpcStruct(body)
+		currentTime := time.Now()
+		response, err = hedge.Do(ctx, methodKey, func(ctx context.Context) (*isp.Message, error) {
+			return client.Request(ctx, message)
+		})
+		elapsed := time.Since(currentTime)
+		service.GetMetrics().UpdateRouterResponseTime(elapsed / 1e6)
+		service.GetMetrics().ObserveRouterResponseTime(elapsed)
+		breakerDone(err)
+		lastErr = err
+		return err
+	})
+	mirror.Send(methodKey, md, message, response, invokerErr)
 
 	if data, status, err := utils.GetResponse(response, invokerErr); err == nil {
 		c.SetStatusCode(status)
 		_, _ = c.Write(data)
-		if cfg.Journal.Enable && service.JournalMethodsMatcher.Match(methodName) {
+		if cfg.Journal.Enable && service.JournalMethodsMatcher.Match(methodKey) {
 			if invokerErr != nil {
 				if err := journal.Client.Error(methodName, body, data, invokerErr); err != nil {
 					log.Warnf(log_code.WarnJournalCouldNotWriteToFile, "could not write to file journal: %v", err)
@@ -90,6 +216,10 @@ func handleJson(c *fasthttp.RequestCtx, method string) {
 	}
 }
 
+func isStreaming(ctx *fasthttp.RequestCtx) bool {
+	return isMultipart(ctx) || string(ctx.Request.Header.Peek(u.ExpectFileHeader)) == "true"
+}
+
 func proxyRequestHandle(ctx *fasthttp.RequestCtx, method string) {
 	isMultipart := isMultipart(ctx)
 	isExpectFile := string(ctx.Request.Header.Peek(u.ExpectFileHeader)) == "true"
@@ -0,0 +1,233 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"isp-convert-service/conf"
+)
+
+const (
+	headerOrigin           = "Origin"
+	headerVary             = "Vary"
+	headerAllowOrigin      = "Access-Control-Allow-Origin"
+	headerAllowMethods     = "Access-Control-Allow-Methods"
+	headerAllowHeaders     = "Access-Control-Allow-Headers"
+	headerAllowCredentials = "Access-Control-Allow-Credentials"
+	headerExposeHeaders    = "Access-Control-Expose-Headers"
+	headerMaxAge           = "Access-Control-Max-Age"
+	headerRequestMethod    = "Access-Control-Request-Method"
+	headerRequestHeaders   = "Access-Control-Request-Headers"
+	anyValue               = "*"
+	defaultAllowedMethods  = "GET, POST"
+	varyPreflight          = "Origin, Access-Control-Request-Method, Access-Control-Request-Headers"
+)
+
+var (
+	policy     = &Policy{}
+	policyLock sync.RWMutex
+)
+
+type Policy struct {
+	enabled          bool
+	origins          originMatcher
+	methods          map[string]bool
+	methodsValue     string
+	headers          map[string]bool
+	anyHeader        bool
+	headersValue     string
+	exposeValue      string
+	allowCredentials bool
+	maxAge           string
+}
+
+// NewPolicy rejects credentials for any origin, since every site could make credentialed requests then
+func NewPolicy(cfg conf.CorsConfig) (*Policy, error) {
+	p := &Policy{
+		enabled:          cfg.Enable,
+		origins:          newOriginMatcher(cfg.AllowedOrigins),
+		methods:          make(map[string]bool),
+		methodsValue:     defaultAllowedMethods,
+		headers:          make(map[string]bool),
+		anyHeader:        len(cfg.AllowedHeaders) == 0,
+		exposeValue:      strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+	}
+	if len(cfg.AllowedMethods) > 0 {
+		methods := make([]string, 0, len(cfg.AllowedMethods))
+		for _, method := range cfg.AllowedMethods {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			p.methods[method] = true
+			methods = append(methods, method)
+		}
+		p.methodsValue = strings.Join(methods, ", ")
+	} else {
+		p.methods[http.MethodGet] = true
+		p.methods[http.MethodPost] = true
+	}
+	for _, header := range cfg.AllowedHeaders {
+		header = strings.TrimSpace(header)
+		if header == anyValue {
+			p.anyHeader = true
+			continue
+		}
+		p.headers[strings.ToLower(header)] = true
+	}
+	if !p.anyHeader {
+		p.headersValue = strings.Join(cfg.AllowedHeaders, ", ")
+	}
+	if cfg.MaxAgeSeconds > 0 {
+		p.maxAge = strconv.Itoa(cfg.MaxAgeSeconds)
+	}
+	if p.origins.any && p.allowCredentials {
+		return nil, errors.New("cors: credentials can't be allowed for any origin '*'")
+	}
+	return p, nil
+}
+
+func ReceiveConfiguration(cfg conf.CorsConfig) error {
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	policyLock.Lock()
+	policy = p
+	policyLock.Unlock()
+	return nil
+}
+
+func GetPolicy() *Policy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return policy
+}
+
+func (p *Policy) Enabled() bool {
+	return p.enabled
+}
+
+// HandlePreflight answers OPTIONS requests on its own, without calling the router
+func (p *Policy) HandlePreflight(ctx *fasthttp.RequestCtx) {
+	header := &ctx.Response.Header
+	header.Set(headerVary, varyPreflight)
+
+	origin := string(ctx.Request.Header.Peek(headerOrigin))
+	method := strings.ToUpper(string(ctx.Request.Header.Peek(headerRequestMethod)))
+	if origin == "" || method == "" {
+		// not a CORS preflight, just an OPTIONS request
+		header.Set("Allow", p.methodsValue+", OPTIONS")
+		ctx.SetStatusCode(http.StatusNoContent)
+		return
+	}
+	if !p.enabled || !p.origins.Match(origin) || !p.methods[method] {
+		ctx.SetStatusCode(http.StatusForbidden)
+		return
+	}
+
+	requestedHeaders := string(ctx.Request.Header.Peek(headerRequestHeaders))
+	if !p.anyHeader {
+		for _, h := range strings.Split(requestedHeaders, ",") {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if h != "" && !p.headers[h] {
+				ctx.SetStatusCode(http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	p.setOrigin(header, origin)
+	header.Set(headerAllowMethods, p.methodsValue)
+	if p.anyHeader {
+		if requestedHeaders != "" {
+			header.Set(headerAllowHeaders, requestedHeaders)
+		}
+	} else if p.headersValue != "" {
+		header.Set(headerAllowHeaders, p.headersValue)
+	}
+	if p.maxAge != "" {
+		header.Set(headerMaxAge, p.maxAge)
+	}
+	ctx.SetStatusCode(http.StatusNoContent)
+}
+
+// Decorate adds CORS headers to an actual request, before it is proxied,
+// so that the browser also gets access to error responses
+func (p *Policy) Decorate(ctx *fasthttp.RequestCtx) {
+	if !p.enabled {
+		return
+	}
+	header := &ctx.Response.Header
+	header.Add(headerVary, headerOrigin)
+	origin := string(ctx.Request.Header.Peek(headerOrigin))
+	if origin == "" || !p.origins.Match(origin) {
+		return
+	}
+	p.setOrigin(header, origin)
+	if p.exposeValue != "" {
+		header.Set(headerExposeHeaders, p.exposeValue)
+	}
+}
+
+func (p *Policy) setOrigin(header *fasthttp.ResponseHeader, origin string) {
+	if p.origins.any {
+		header.Set(headerAllowOrigin, anyValue)
+	} else {
+		header.Set(headerAllowOrigin, origin)
+	}
+	if p.allowCredentials {
+		header.Set(headerAllowCredentials, "true")
+	}
+}
+
+type originMatcher struct {
+	any       bool
+	exact     map[string]bool
+	wildcards []wildcardOrigin
+}
+
+type wildcardOrigin struct {
+	prefix string
+	suffix string
+}
+
+func newOriginMatcher(origins []string) originMatcher {
+	m := originMatcher{exact: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch {
+		case origin == anyValue:
+			m.any = true
+		case strings.Contains(origin, anyValue):
+			i := strings.Index(origin, anyValue)
+			m.wildcards = append(m.wildcards, wildcardOrigin{prefix: origin[:i], suffix: origin[i+1:]})
+		case origin != "":
+			m.exact[origin] = true
+		}
+	}
+	return m
+}
+
+func (m originMatcher) Match(origin string) bool {
+	if m.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+	for _, w := range m.wildcards {
+		if len(origin) <= len(w.prefix)+len(w.suffix) ||
+			!strings.HasPrefix(origin, w.prefix) || !strings.HasSuffix(origin, w.suffix) {
+			continue
+		}
+		// the wildcard stands for subdomains only, not for a scheme, port or credentials
+		if !strings.ContainsAny(origin[len(w.prefix):len(origin)-len(w.suffix)], "/:@") {
+			return true
+		}
+	}
+	return false
+}
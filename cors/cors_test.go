@@ -0,0 +1,59 @@
+package cors
+
+import (
+	"testing"
+
+	"isp-convert-service/conf"
+)
+
+var (
+	origins = []string{
+		"https://app.example.com",
+		"https://*.partner.com",
+		"http://localhost:*",
+	}
+	originCases = []struct {
+		Origin string
+		Result bool
+	}{
+		{Origin: "https://app.example.com", Result: true},
+		{Origin: "HTTPS://APP.EXAMPLE.COM", Result: true},
+		{Origin: "http://app.example.com", Result: false},
+		{Origin: "https://a.partner.com", Result: true},
+		{Origin: "https://a.b.partner.com", Result: true},
+		{Origin: "https://partner.com", Result: false},
+		{Origin: "https://.partner.com", Result: false},
+		{Origin: "https://evil.com/.partner.com", Result: false},
+		{Origin: "https://evilpartner.com", Result: false},
+		{Origin: "http://localhost:3000", Result: true},
+		{Origin: "https://other.com", Result: false},
+	}
+)
+
+func TestOriginMatcher_Match(t *testing.T) {
+	matcher := newOriginMatcher(origins)
+	for _, c := range originCases {
+		if res := matcher.Match(c.Origin); res != c.Result {
+			t.Error(c)
+		}
+	}
+}
+
+func TestOriginMatcher_Any(t *testing.T) {
+	matcher := newOriginMatcher([]string{"*"})
+	if !matcher.Match("https://any.com") {
+		t.Error("expected any origin to match")
+	}
+	if newOriginMatcher(nil).Match("https://any.com") {
+		t.Error("expected no origin to match empty list")
+	}
+}
+
+func TestNewPolicy_CredentialsForAnyOrigin(t *testing.T) {
+	if _, err := NewPolicy(conf.CorsConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("credentials for any origin must be rejected")
+	}
+	if _, err := NewPolicy(conf.CorsConfig{AllowedOrigins: origins, AllowCredentials: true}); err != nil {
+		t.Errorf("credentials for listed origins must be accepted: %v", err)
+	}
+}
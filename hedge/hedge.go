@@ -0,0 +1,220 @@
+package hedge
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/integration-system/isp-lib/proto/stubs"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+	"isp-convert-service/conf"
+	"isp-convert-service/invoker"
+	"isp-convert-service/retry"
+	"isp-convert-service/service"
+)
+
+const (
+	// minSamples is the number of latency samples the percentile is trusted from, the fixed delay is used before
+	minSamples = 100
+	// maxMethods limits the number of methods with latency statistics per policy
+	maxMethods     = 1000
+	latencySamples = 1028
+	latencyDecay   = 0.015
+)
+
+var (
+	policies     []*policy
+	policiesLock sync.RWMutex
+)
+
+type policy struct {
+	methods    service.MethodMatcher
+	percentile float64
+	delay      time.Duration
+	minDelay   time.Duration
+	// budget limits the share of hedged calls, so a slow router is not loaded twice as much
+	budget *retry.Budget
+	config conf.HedgePolicyConfig
+
+	latencyLock sync.RWMutex
+	latencies   map[string]metrics.Histogram
+
+	responseTime      func(method string, percentile float64) (float64, int64)
+	onHedge           func()
+	onHedgeWon        func()
+	onBudgetExhausted func()
+}
+
+type Call func(ctx context.Context) (*isp.Message, error)
+
+type result struct {
+	response *isp.Message
+	err      error
+	hedged   bool
+}
+
+func newPolicy(cfg conf.HedgePolicyConfig) *policy {
+	p := &policy{
+		methods:    service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+		percentile: cfg.GetPercentile() / 100,
+		delay:      cfg.GetDelay(),
+		minDelay:   time.Duration(cfg.MinDelayMs) * time.Millisecond,
+		budget:     retry.NewBudget(float64(cfg.GetBudgetPercent())/100, cfg.GetMinHedgesPerSecond()),
+		config:     cfg,
+		latencies:  make(map[string]metrics.Histogram),
+
+		onHedge:           func() { service.GetMetrics().UpdateHedge() },
+		onHedgeWon:        func() { service.GetMetrics().UpdateHedgeWon() },
+		onBudgetExhausted: func() { service.GetMetrics().UpdateHedgeBudgetExhausted() },
+	}
+	p.responseTime = p.latencyPercentile
+	return p
+}
+
+// ReceiveConfiguration keeps policies with unchanged configuration, so their budgets are not reset
+func ReceiveConfiguration(list []conf.HedgePolicyConfig) {
+	policiesLock.RLock()
+	prev := policies
+	policiesLock.RUnlock()
+
+	next := make([]*policy, 0, len(list))
+	for _, cfg := range list {
+		p := newPolicy(cfg)
+		for _, old := range prev {
+			if reflect.DeepEqual(old.config, cfg) {
+				p = old
+				break
+			}
+		}
+		next = append(next, p)
+	}
+	policiesLock.Lock()
+	policies = next
+	policiesLock.Unlock()
+}
+
+// observe records the latency of a successful router call of the method in milliseconds.
+// Statistics are kept by the policy itself, independent of the query and the http status of responses
+func (p *policy) observe(method string, latency time.Duration) {
+	p.latencyLock.RLock()
+	h, ok := p.latencies[method]
+	p.latencyLock.RUnlock()
+	if !ok {
+		p.latencyLock.Lock()
+		if h, ok = p.latencies[method]; !ok {
+			if len(p.latencies) >= maxMethods {
+				p.latencyLock.Unlock()
+				return
+			}
+			h = metrics.NewHistogram(metrics.NewExpDecaySample(latencySamples, latencyDecay))
+			p.latencies[method] = h
+		}
+		p.latencyLock.Unlock()
+	}
+	h.Update(int64(latency / time.Millisecond))
+}
+
+func (p *policy) latencyPercentile(method string, percentile float64) (float64, int64) {
+	p.latencyLock.RLock()
+	h, ok := p.latencies[method]
+	p.latencyLock.RUnlock()
+	if !ok {
+		return 0, 0
+	}
+	return h.Percentile(percentile), h.Count()
+}
+
+// delay of the hedge is the configured percentile of the method response time, measured in milliseconds
+func (p *policy) hedgeDelay(method string) time.Duration {
+	value, count := p.responseTime(method, p.percentile)
+	if count < minSamples {
+		return p.delay
+	}
+	delay := time.Duration(value * float64(time.Millisecond))
+	if delay < p.minDelay {
+		return p.minDelay
+	}
+	return delay
+}
+
+// Applies reports whether calls of the method may be hedged
+func Applies(method string) bool {
+	policiesLock.RLock()
+	list := policies
+	policiesLock.RUnlock()
+
+	for _, p := range list {
+		if p.methods.Match(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do sends a second request if the first one has not answered within the hedge delay of the method
+// and returns the first success, the other request is cancelled. The hedged request avoids the router
+// instance of the first one. The method is expected without the query.
+// The losing call may still run after Do returns, so it must not use buffers reused by the caller
+func Do(ctx context.Context, method string, call Call) (*isp.Message, error) {
+	policiesLock.RLock()
+	list := policies
+	policiesLock.RUnlock()
+
+	for _, p := range list {
+		if p.methods.Match(method) {
+			return p.do(ctx, method, call)
+		}
+	}
+	return call(ctx)
+}
+
+func (p *policy) do(ctx context.Context, method string, call Call) (*isp.Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p.budget.Call()
+	primaryCtx, hedgedCtx := invoker.HedgeContexts(ctx)
+	results := make(chan result, 2)
+	send := func(ctx context.Context, hedged bool) {
+		start := time.Now()
+		response, err := call(ctx)
+		if err == nil {
+			p.observe(method, time.Since(start))
+		}
+		results <- result{response: response, err: err, hedged: hedged}
+	}
+	go send(primaryCtx, false)
+
+	timer := time.NewTimer(p.hedgeDelay(method))
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.response, r.err
+	case <-timer.C:
+	}
+
+	if !p.budget.Withdraw() {
+		if p.onBudgetExhausted != nil {
+			p.onBudgetExhausted()
+		}
+		r := <-results
+		return r.response, r.err
+	}
+	if p.onHedge != nil {
+		p.onHedge()
+	}
+	go send(hedgedCtx, true)
+	first := <-results
+	if first.err == nil {
+		if first.hedged && p.onHedgeWon != nil {
+			p.onHedgeWon()
+		}
+		return first.response, nil
+	}
+	second := <-results
+	if second.err == nil && second.hedged && p.onHedgeWon != nil {
+		p.onHedgeWon()
+	}
+	return second.response, second.err
+}
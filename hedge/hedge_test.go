@@ -0,0 +1,139 @@
+package hedge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/integration-system/isp-lib/proto/stubs"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/retry"
+)
+
+func newTestPolicy(delay time.Duration, budget *retry.Budget) *policy {
+	p := newPolicy(conf.HedgePolicyConfig{MethodsPatterns: []string{"*/*/*"}, DelayMs: int64(delay / time.Millisecond)})
+	p.budget = budget
+	p.responseTime = func(string, float64) (float64, int64) {
+		return 0, 0
+	}
+	p.onHedge, p.onHedgeWon, p.onBudgetExhausted = nil, nil, nil
+	return p
+}
+
+func TestPolicy_HedgeDelay(t *testing.T) {
+	p := newTestPolicy(50*time.Millisecond, nil)
+	p.minDelay = 20 * time.Millisecond
+	if d := p.hedgeDelay("module/group/method"); d != 50*time.Millisecond {
+		t.Errorf("expected fixed delay without statistics, got %s", d)
+	}
+
+	p.responseTime = func(method string, percentile float64) (float64, int64) {
+		return 30, minSamples
+	}
+	if d := p.hedgeDelay("module/group/method"); d != 30*time.Millisecond {
+		t.Errorf("expected percentile of response time, got %s", d)
+	}
+	p.responseTime = func(method string, percentile float64) (float64, int64) {
+		return 5, minSamples
+	}
+	if d := p.hedgeDelay("module/group/method"); d != 20*time.Millisecond {
+		t.Errorf("expected minimal delay, got %s", d)
+	}
+}
+
+func TestPolicy_Do_FastCallIsNotHedged(t *testing.T) {
+	p := newTestPolicy(time.Second, retry.NewBudget(1, 10))
+	calls := 0
+	response, err := p.do(context.Background(), "", func(ctx context.Context) (*isp.Message, error) {
+		calls++
+		return &isp.Message{}, nil
+	})
+	if err != nil || response == nil || calls != 1 {
+		t.Errorf("expected single call, got %d calls: %v", calls, err)
+	}
+}
+
+func TestPolicy_Do_FirstSuccessWins(t *testing.T) {
+	p := newTestPolicy(10*time.Millisecond, retry.NewBudget(1, 10))
+	won := 0
+	p.onHedgeWon = func() { won++ }
+
+	first := &isp.Message{}
+	hedged := &isp.Message{}
+	started := make(chan context.Context, 2)
+	cancelled := make(chan struct{})
+	response, err := p.do(context.Background(), "", func(ctx context.Context) (*isp.Message, error) {
+		started <- ctx
+		if len(started) == 1 {
+			// the first call hangs until it is cancelled by the successful hedge
+			<-ctx.Done()
+			close(cancelled)
+			return first, ctx.Err()
+		}
+		return hedged, nil
+	})
+	if err != nil || response != hedged {
+		t.Fatalf("expected response of the hedged call, got %v", err)
+	}
+	if won != 1 {
+		t.Errorf("expected hedge to be counted as won, got %d", won)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("losing call is expected to be cancelled")
+	}
+}
+
+func TestPolicy_Do_FailureWaitsForOtherCall(t *testing.T) {
+	p := newTestPolicy(10*time.Millisecond, retry.NewBudget(1, 10))
+	release := make(chan struct{})
+	calls := make(chan struct{}, 2)
+	expected := &isp.Message{}
+	response, err := p.do(context.Background(), "", func(ctx context.Context) (*isp.Message, error) {
+		calls <- struct{}{}
+		if len(calls) == 1 {
+			<-release
+			return expected, nil
+		}
+		close(release)
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	})
+	if err != nil || response != expected {
+		t.Errorf("expected success of the first call after the hedge failed, got %v", err)
+	}
+}
+
+func TestPolicy_Do_Budget(t *testing.T) {
+	p := newTestPolicy(time.Millisecond, retry.NewBudget(0, 0))
+	exhausted := 0
+	p.onBudgetExhausted = func() { exhausted++ }
+	calls := 0
+	_, err := p.do(context.Background(), "", func(ctx context.Context) (*isp.Message, error) {
+		calls++
+		time.Sleep(10 * time.Millisecond)
+		return &isp.Message{}, nil
+	})
+	if err != nil || calls != 1 || exhausted != 1 {
+		t.Errorf("expected hedge to be rejected by the budget, got %d calls, %d exhausted: %v", calls, exhausted, err)
+	}
+}
+
+func TestPolicy_ObserveByMethod(t *testing.T) {
+	p := newPolicy(conf.HedgePolicyConfig{MethodsPatterns: []string{"*/*/*"}, DelayMs: 50, Percentile: 50})
+	p.budget = retry.NewBudget(0, 0)
+	p.onHedge, p.onHedgeWon, p.onBudgetExhausted = nil, nil, nil
+	for i := 0; i < minSamples; i++ {
+		_, _ = p.do(context.Background(), "module/group/method", func(ctx context.Context) (*isp.Message, error) {
+			return &isp.Message{}, nil
+		})
+	}
+	if _, count := p.responseTime("module/group/method", 0.5); count != minSamples {
+		t.Fatalf("expected %d samples of the method, got %d", minSamples, count)
+	}
+	if d := p.hedgeDelay("module/group/method"); d >= 50*time.Millisecond {
+		t.Errorf("expected delay by the percentile of observed calls, got %s", d)
+	}
+}
@@ -0,0 +1,275 @@
+package invoker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/integration-system/isp-log"
+	"github.com/pkg/errors"
+	"isp-convert-service/balancer"
+	"isp-convert-service/conf"
+	"isp-convert-service/log_code"
+	"isp-convert-service/service"
+	"isp-convert-service/tlsutil"
+)
+
+const (
+	defaultClusterName = "router"
+)
+
+var (
+	clusters       []*cluster
+	defaultStandby string
+	clustersLock   sync.RWMutex
+)
+
+// cluster is a named router pool serving methods matching its patterns
+type cluster struct {
+	name          string
+	methods       service.MethodMatcher
+	serving       bool
+	standby       string
+	pool          *pool
+	credentials   *tlsutil.ClientCredentials
+	syncTimeout   time.Duration
+	streamTimeout time.Duration
+}
+
+func (c *cluster) close() {
+	c.pool.close()
+	c.credentials.Close()
+}
+
+// ReceiveClustersConfiguration replaces the list of named clusters, pools of clusters with the same name
+// are reconfigured and keep their connections. The whole list is validated and certificates are loaded
+// before any cluster is changed, balancer settings are restored if one of them fails,
+// so an invalid configuration leaves all clusters as they are
+func ReceiveClustersConfiguration(list []conf.RouterClusterConfig, failover conf.FailoverConfig) error {
+	names := make(map[string]bool, len(list))
+	for i, cfg := range list {
+		if cfg.Name == "" {
+			return errors.Errorf("cluster %d: name is not specified", i)
+		}
+		if cfg.Name == defaultClusterName || names[cfg.Name] {
+			return errors.Errorf("cluster %d: name '%s' is already used", i, cfg.Name)
+		}
+		names[cfg.Name] = true
+		if len(cfg.Addresses) == 0 {
+			return errors.Errorf("cluster %s: addresses are not specified", cfg.Name)
+		}
+		if err := balancer.Validate(cfg.Balancer.Algorithm); err != nil {
+			return errors.Wrapf(err, "cluster %s", cfg.Name)
+		}
+	}
+	for _, cfg := range list {
+		if cfg.StandbyCluster != "" && (cfg.StandbyCluster == cfg.Name || !names[cfg.StandbyCluster]) {
+			return errors.Errorf("cluster %s: unknown standby cluster '%s'", cfg.Name, cfg.StandbyCluster)
+		}
+	}
+	if failover.StandbyCluster != "" && !names[failover.StandbyCluster] {
+		return errors.Errorf("unknown standby cluster '%s'", failover.StandbyCluster)
+	}
+	transports := make([]tlsutil.LoadedClientSettings, 0, len(list))
+	for _, cfg := range list {
+		loaded, err := tlsutil.LoadClientSettings(tlsutil.NewClientSettings(cfg.Transport), tlsutil.LogReload("router cluster "+cfg.Name))
+		if err != nil {
+			return errors.Wrapf(err, "cluster %s", cfg.Name)
+		}
+		transports = append(transports, loaded)
+	}
+
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+
+	prev := make(map[string]*cluster, len(clusters))
+	for _, c := range clusters {
+		prev[c.name] = c
+	}
+	// balancer settings are the only fallible part, they are applied to all pools first
+	// and restored on error, so the rest of the configuration is applied only once all of them succeed
+	resolved := make([]*cluster, 0, len(list))
+	created := make([]*cluster, 0)
+	restore := make(map[*pool]conf.BalancerConfig)
+	for _, cfg := range list {
+		c, ok := prev[cfg.Name]
+		if !ok {
+			credentials := tlsutil.NewClientCredentials()
+			c = &cluster{name: cfg.Name, credentials: credentials, pool: newPool(cfg.Name, credentials)}
+			created = append(created, c)
+		} else {
+			restore[c.pool] = c.pool.balancerSettings()
+		}
+		if err := c.pool.configure(cfg.Balancer); err != nil {
+			for p, settings := range restore {
+				_ = p.configure(settings)
+			}
+			for _, c := range created {
+				c.close()
+			}
+			return errors.Wrapf(err, "cluster %s", cfg.Name)
+		}
+		resolved = append(resolved, c)
+	}
+
+	next := make([]*cluster, 0, len(list))
+	for i, cfg := range list {
+		delete(prev, cfg.Name)
+		next = append(next, configureCluster(resolved[i], cfg, transports[i]))
+	}
+	for _, c := range prev {
+		c.close()
+	}
+	clusters = next
+	defaultStandby = failover.StandbyCluster
+	return nil
+}
+
+// configureCluster applies the transport, dial options and addresses to the pool of the cluster
+// and returns a new cluster with the same pool, resolved clusters are never modified
+func configureCluster(c *cluster, cfg conf.RouterClusterConfig, transport tlsutil.LoadedClientSettings) *cluster {
+	modeChanged := c.credentials.Apply(transport)
+	if modeChanged {
+		c.pool.redial()
+	}
+	c.pool.setDial(cfg.Dial)
+	c.pool.update(cfg.Addresses)
+
+	return &cluster{
+		name:          c.name,
+		methods:       service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+		serving:       len(cfg.MethodsPatterns) > 0,
+		standby:       cfg.StandbyCluster,
+		pool:          c.pool,
+		credentials:   c.credentials,
+		syncTimeout:   time.Duration(cfg.SyncInvokeMethodTimeoutMs) * time.Millisecond,
+		streamTimeout: time.Duration(cfg.StreamInvokeMethodTimeoutMs) * time.Millisecond,
+	}
+}
+
+// route chooses the pool for the method: the named cluster, the first cluster matching the method
+// or the default router pool, or their standby cluster while none of their instances is available.
+// Calls fail back once the primary pool has an available instance again.
+// Timeouts of the route are taken from the chosen cluster, so a standby uses its own ones
+func route(method, cluster string) (*pool, Route) {
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+
+	primary, standby := routerPool, defaultStandby
+	primaryRoute := Route{Cluster: defaultClusterName}
+	c := findCluster(cluster)
+	if c == nil && cluster != defaultClusterName {
+		c = matchCluster(method)
+	}
+	if c != nil {
+		primary, standby, primaryRoute = c.pool, c.standby, c.route()
+	}
+	if standby == "" || primary.available() {
+		setFailover(primary, primaryRoute.Cluster, "", false)
+		return primary, primaryRoute
+	}
+	if c := findCluster(standby); c != nil && c.pool.available() {
+		setFailover(primary, primaryRoute.Cluster, standby, true)
+		service.GetMetrics().UpdateRouterFailover(primaryRoute.Cluster)
+		r := c.route()
+		r.Failover = true
+		return c.pool, r
+	}
+	// the standby is unavailable too, calls get errors of the primary pool
+	return primary, primaryRoute
+}
+
+func (c *cluster) route() Route {
+	return Route{Cluster: c.name, syncTimeout: c.syncTimeout, streamTimeout: c.streamTimeout}
+}
+
+func setFailover(p *pool, name, standby string, active bool) {
+	value := int32(0)
+	if active {
+		value = 1
+	}
+	if atomic.SwapInt32(&p.failedOver, value) == value {
+		return
+	}
+	if active {
+		log.Warnf(log_code.WarnRouterFailover, "router cluster %s is unavailable, calls go to standby cluster %s", name, standby)
+	} else {
+		log.Infof(log_code.WarnRouterFailover, "router cluster %s is available again, calls fail back to it", name)
+	}
+	service.GetMetrics().UpdateRouterFailoverActive(name, active)
+}
+
+// HasCluster reports whether the named cluster is configured, the default router pool is named router
+func HasCluster(name string) bool {
+	if name == defaultClusterName {
+		return true
+	}
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	return findCluster(name) != nil
+}
+
+// CheckShadowCluster reports an error unless the named cluster is dedicated to shadow calls:
+// it is not the default router pool, serves no methods and is not a standby of another pool
+func CheckShadowCluster(name string) error {
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	_, err := shadowCluster(name)
+	return err
+}
+
+// shadowCluster must be called under the lock
+func shadowCluster(name string) (*cluster, error) {
+	if name == defaultClusterName {
+		return nil, errors.Errorf("cluster '%s' serves client calls", name)
+	}
+	c := findCluster(name)
+	if c == nil {
+		return nil, errors.Errorf("unknown cluster '%s'", name)
+	}
+	if c.serving {
+		return nil, errors.Errorf("cluster '%s' serves client calls", name)
+	}
+	if name == defaultStandby {
+		return nil, errors.Errorf("cluster '%s' is the standby of router cluster '%s'", name, defaultClusterName)
+	}
+	for _, other := range clusters {
+		if other.standby == name {
+			return nil, errors.Errorf("cluster '%s' is the standby of router cluster '%s'", name, other.name)
+		}
+	}
+	return c, nil
+}
+
+// findCluster must be called under the lock
+func findCluster(name string) *cluster {
+	if name == "" {
+		return nil
+	}
+	for _, c := range clusters {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// matchCluster must be called under the lock
+func matchCluster(method string) *cluster {
+	for _, c := range clusters {
+		if c.methods.Match(method) {
+			return c
+		}
+	}
+	return nil
+}
+
+func closeClusters() {
+	clustersLock.Lock()
+	list := clusters
+	clusters = nil
+	clustersLock.Unlock()
+	for _, c := range list {
+		c.close()
+	}
+}
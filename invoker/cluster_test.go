@@ -0,0 +1,296 @@
+package invoker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/integration-system/isp-lib/structure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+)
+
+func clusterConfig(name string, methods ...string) conf.RouterClusterConfig {
+	return conf.RouterClusterConfig{
+		Name:            name,
+		MethodsPatterns: methods,
+		Addresses:       []structure.AddressConfiguration{{IP: "127.0.0.1", Port: "9001"}},
+	}
+}
+
+func clusterNames() []string {
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	names := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func setAvailable(p *pool, available bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, i := range p.instances {
+		i.SetHealthy(available)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	err := ReceiveClustersConfiguration([]conf.RouterClusterConfig{
+		clusterConfig("reports", "reports/*/*"),
+		clusterConfig("archive", "reports/archive/*", "archive/*/*"),
+		clusterConfig("standby"),
+	}, conf.FailoverConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClusters()
+
+	cases := []struct {
+		method  string
+		named   string
+		cluster string
+	}{
+		{method: "reports/daily/get", cluster: "reports"},
+		{method: "reports/archive/get", cluster: "reports"},
+		{method: "archive/daily/get", cluster: "archive"},
+		{method: "catalog/item/get", cluster: defaultClusterName},
+		{method: "catalog/item/get", named: "standby", cluster: "standby"},
+		{method: "reports/daily/get", named: defaultClusterName, cluster: defaultClusterName},
+		{method: "reports/daily/get", named: "unknown", cluster: "reports"},
+	}
+	for _, c := range cases {
+		if _, r := route(c.method, c.named); r.Cluster != c.cluster || r.Failover {
+			t.Errorf("%s via '%s': expected cluster '%s', got %+v", c.method, c.named, c.cluster, r)
+		}
+	}
+	if !HasCluster("standby") || !HasCluster(defaultClusterName) || HasCluster("unknown") {
+		t.Error("unexpected result of HasCluster")
+	}
+}
+
+func TestRoute_Failover(t *testing.T) {
+	service.InitMetrics()
+	primary := clusterConfig("reports", "reports/*/*")
+	primary.StandbyCluster = "standby"
+	primary.SyncInvokeMethodTimeoutMs = 1000
+	standby := clusterConfig("standby")
+	standby.SyncInvokeMethodTimeoutMs = 5000
+	standby.StreamInvokeMethodTimeoutMs = 7000
+	if err := ReceiveClustersConfiguration([]conf.RouterClusterConfig{primary, standby}, conf.FailoverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	defer closeClusters()
+	clustersLock.RLock()
+	primaryPool, standbyPool := findCluster("reports").pool, findCluster("standby").pool
+	clustersLock.RUnlock()
+
+	p, r := route("reports/daily/get", "")
+	if p != primaryPool || r.Failover || r.SyncInvokeTimeout(time.Minute) != time.Second || r.StreamInvokeTimeout(time.Minute) != time.Minute {
+		t.Errorf("expected primary cluster with its timeouts, got %+v", r)
+	}
+
+	setAvailable(primaryPool, false)
+	p, r = route("reports/daily/get", "")
+	if p != standbyPool || !r.Failover || r.Cluster != "standby" {
+		t.Fatalf("expected failover to standby cluster, got %+v", r)
+	}
+	if r.SyncInvokeTimeout(time.Minute) != 5*time.Second || r.StreamInvokeTimeout(time.Minute) != 7*time.Second {
+		t.Errorf("expected timeouts of standby cluster, got %+v", r)
+	}
+	if atomic.LoadInt32(&primaryPool.failedOver) != 1 {
+		t.Error("primary pool is expected to be marked as failed over")
+	}
+
+	setAvailable(standbyPool, false)
+	if p, r = route("reports/daily/get", ""); p != primaryPool || r.Failover {
+		t.Errorf("calls are expected to stay on primary cluster while standby is unavailable too, got %+v", r)
+	}
+	setAvailable(standbyPool, true)
+
+	setAvailable(primaryPool, true)
+	if p, r = route("reports/daily/get", ""); p != primaryPool || r.Failover || r.Cluster != "reports" {
+		t.Errorf("expected failback to primary cluster, got %+v", r)
+	}
+	if atomic.LoadInt32(&primaryPool.failedOver) != 0 {
+		t.Error("primary pool is expected to be marked as failed back")
+	}
+}
+
+func TestRoute_DefaultStandby(t *testing.T) {
+	service.InitMetrics()
+	if err := ReceiveClustersConfiguration([]conf.RouterClusterConfig{clusterConfig("standby")}, conf.FailoverConfig{StandbyCluster: "standby"}); err != nil {
+		t.Fatal(err)
+	}
+	defer closeClusters()
+
+	// the default router pool has no instances in tests, so it is never available
+	if _, r := route("catalog/item/get", ""); r.Cluster != "standby" || !r.Failover {
+		t.Errorf("expected failover of default router pool, got %+v", r)
+	}
+	if err := ReceiveClustersConfiguration(nil, conf.FailoverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, r := route("catalog/item/get", ""); r.Cluster != defaultClusterName || r.Failover {
+		t.Errorf("expected default router pool without standby, got %+v", r)
+	}
+}
+
+func TestReceiveClustersConfiguration(t *testing.T) {
+	err := ReceiveClustersConfiguration([]conf.RouterClusterConfig{
+		clusterConfig("reports", "reports/*/*"),
+		clusterConfig("archive", "archive/*/*"),
+	}, conf.FailoverConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClusters()
+	clustersLock.RLock()
+	reports, archive := findCluster("reports"), findCluster("archive")
+	clustersLock.RUnlock()
+
+	invalid := []struct {
+		name string
+		list []conf.RouterClusterConfig
+	}{
+		{name: "duplicate name", list: []conf.RouterClusterConfig{clusterConfig("reports"), clusterConfig("reports")}},
+		{name: "reserved name", list: []conf.RouterClusterConfig{clusterConfig(defaultClusterName)}},
+		{name: "no addresses", list: []conf.RouterClusterConfig{{Name: "reports"}}},
+		{name: "unknown standby", list: []conf.RouterClusterConfig{func() conf.RouterClusterConfig {
+			cfg := clusterConfig("reports")
+			cfg.StandbyCluster = "unknown"
+			return cfg
+		}()}},
+		{name: "missing certificates", list: []conf.RouterClusterConfig{
+			clusterConfig("reports", "reports/*/*"),
+			func() conf.RouterClusterConfig {
+				cfg := clusterConfig("secure")
+				cfg.Transport.EnableTls = true
+				cfg.Transport.CaFiles = []string{"/nonexistent/ca.pem"}
+				return cfg
+			}(),
+		}},
+	}
+	for _, c := range invalid {
+		if err := ReceiveClustersConfiguration(c.list, conf.FailoverConfig{}); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+		if names := clusterNames(); len(names) != 2 || names[0] != "reports" || names[1] != "archive" {
+			t.Errorf("%s: previous clusters are expected to stay, got %v", c.name, names)
+		}
+	}
+	if p, _ := route("reports/daily/get", ""); p != reports.pool {
+		t.Error("invalid configuration must not change clusters")
+	}
+
+	err = ReceiveClustersConfiguration([]conf.RouterClusterConfig{
+		clusterConfig("reports", "reports/*/*", "archive/*/*"),
+	}, conf.FailoverConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, r := route("archive/daily/get", ""); r.Cluster != "reports" || p != reports.pool {
+		t.Error("reconfigured cluster is expected to keep its pool and use new methods")
+	}
+	clustersLock.RLock()
+	reconfigured := findCluster("reports")
+	clustersLock.RUnlock()
+	if reconfigured == reports {
+		t.Error("resolved clusters must not be modified in place")
+	}
+	archive.pool.lock.RLock()
+	stopped := archive.pool.stop == nil && len(archive.pool.conns) == 0
+	archive.pool.lock.RUnlock()
+	if !stopped {
+		t.Error("removed cluster is expected to be closed")
+	}
+}
+
+func TestShadowConn(t *testing.T) {
+	service.InitMetrics()
+	primary := clusterConfig("reports", "reports/*/*")
+	primary.StandbyCluster = "standby"
+	shadow := clusterConfig("shadow")
+	shadow.StandbyCluster = "standby"
+	err := ReceiveClustersConfiguration([]conf.RouterClusterConfig{
+		primary, shadow, clusterConfig("standby"), clusterConfig("backup"),
+	}, conf.FailoverConfig{StandbyCluster: "backup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClusters()
+
+	for _, name := range []string{defaultClusterName, "reports", "standby", "backup", "unknown"} {
+		if err := CheckShadowCluster(name); err == nil {
+			t.Errorf("cluster '%s' must not be accepted as shadow", name)
+		}
+		if _, err := ShadowConn(name); err == nil {
+			t.Errorf("shadow calls must not be sent to cluster '%s'", name)
+		}
+	}
+	if err := CheckShadowCluster("shadow"); err != nil {
+		t.Fatal(err)
+	}
+
+	clustersLock.RLock()
+	shadowPool := findCluster("shadow").pool
+	clustersLock.RUnlock()
+	setAvailable(shadowPool, false)
+	client, err := ShadowConn("shadow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := client.(balancedClient); !ok || c.pool != shadowPool {
+		t.Error("shadow calls must use the pool of the shadow cluster without failover")
+	}
+	if atomic.LoadInt32(&shadowPool.failedOver) != 0 {
+		t.Error("shadow calls must not change the failover state")
+	}
+}
+
+func TestRoute_FailoverByEjection(t *testing.T) {
+	service.InitMetrics()
+	primary := clusterConfig("reports", "reports/*/*")
+	primary.Addresses = append(primary.Addresses, structure.AddressConfiguration{IP: "127.0.0.1", Port: "9002"})
+	primary.StandbyCluster = "standby"
+	primary.Balancer.OutlierDetection = conf.OutlierDetectionConfig{Enable: true, ConsecutiveFailures: 1}
+	if err := ReceiveClustersConfiguration([]conf.RouterClusterConfig{primary, clusterConfig("standby")}, conf.FailoverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	defer closeClusters()
+	clustersLock.RLock()
+	primaryPool := findCluster("reports").pool
+	clustersLock.RUnlock()
+	failAll := func() {
+		primaryPool.lock.RLock()
+		instances := primaryPool.instances
+		primaryPool.lock.RUnlock()
+		for _, i := range instances {
+			primaryPool.record(i, status.Error(codes.Unavailable, "unavailable"))
+		}
+	}
+
+	// the default cap keeps half of the instances, so call errors alone never fail over
+	failAll()
+	if _, r := route("reports/daily/get", ""); r.Failover {
+		t.Error("outlier ejection must not eject past the max ejected percent")
+	}
+	// health checks are not limited by the cap
+	setAvailable(primaryPool, false)
+	if _, r := route("reports/daily/get", ""); !r.Failover {
+		t.Error("instances failing health checks must fail over")
+	}
+	setAvailable(primaryPool, true)
+
+	primary.Balancer.OutlierDetection.MaxEjectedPercent = 100
+	if err := ReceiveClustersConfiguration([]conf.RouterClusterConfig{primary, clusterConfig("standby")}, conf.FailoverConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	failAll()
+	if _, r := route("reports/daily/get", ""); !r.Failover {
+		t.Error("ejection of every instance must fail over with the cap of 100 percent")
+	}
+}
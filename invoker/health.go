@@ -0,0 +1,163 @@
+package invoker
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/integration-system/isp-log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/balancer"
+	"isp-convert-service/conf"
+	"isp-convert-service/log_code"
+	"isp-convert-service/service"
+)
+
+const (
+	maintenancePeriod = time.Second
+)
+
+var (
+	// instanceFailureCodes are the results of live calls which count against the instance, other errors
+	// come from backends behind the router
+	instanceFailureCodes = map[codes.Code]bool{
+		codes.Unavailable:      true,
+		codes.DeadlineExceeded: true,
+	}
+)
+
+type healthCounter struct {
+	failures  int
+	successes int
+}
+
+// maintain runs active health checks, sweeps outliers and reports instance metrics
+func (p *pool) maintain(stop chan struct{}) {
+	ticker := time.NewTicker(maintenancePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			p.lock.RLock()
+			health, outlier := p.settings.HealthCheck, p.settings.OutlierDetection
+			detector, instances := p.detector, p.instances
+			checkDue := health.Enable && now.Sub(p.lastCheck) >= health.GetInterval()
+			sweepDue := detector != nil && now.Sub(p.lastSweep) >= outlier.GetInterval()
+			p.lock.RUnlock()
+
+			if checkDue {
+				p.checkHealth(now)
+			}
+			if sweepDue {
+				p.lock.Lock()
+				p.lastSweep = now
+				p.lock.Unlock()
+				for _, i := range detector.Sweep(instances) {
+					logEjection(i)
+				}
+			}
+			// instances are read again under the lock, removed ones must not be reported
+			p.lock.RLock()
+			reportInstances(p.instances)
+			p.lock.RUnlock()
+		}
+	}
+}
+
+func (p *pool) checkHealth(now time.Time) {
+	p.lock.Lock()
+	p.lastCheck = now
+	cfg := p.settings.HealthCheck
+	conns := make([]*routerConn, 0, len(p.conns))
+	for _, c := range p.conns {
+		conns = append(conns, c)
+	}
+	p.lock.Unlock()
+
+	results := make([]bool, len(conns))
+	wg := sync.WaitGroup{}
+	for k, c := range conns {
+		wg.Add(1)
+		go func(k int, c *routerConn) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.GetTimeout())
+			defer cancel()
+			resp, err := c.health.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: cfg.Service})
+			results[k] = err == nil && resp.GetStatus() == grpc_health_v1.HealthCheckResponse_SERVING
+		}(k, c)
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for k, c := range conns {
+		counter, ok := p.checks[c.instance.Address]
+		if !ok {
+			counter = &healthCounter{}
+			p.checks[c.instance.Address] = counter
+		}
+		if results[k] {
+			counter.failures = 0
+			counter.successes++
+			if !c.instance.Healthy() && counter.successes >= cfg.GetHealthyThreshold() {
+				c.instance.SetHealthy(true)
+				log.Infof(log_code.WarnRouterInstanceHealth, "router instance %s is healthy", c.instance.Address)
+			}
+		} else {
+			counter.successes = 0
+			counter.failures++
+			if c.instance.Healthy() && counter.failures >= cfg.GetUnhealthyThreshold() {
+				c.instance.SetHealthy(false)
+				log.Warnf(log_code.WarnRouterInstanceHealth, "router instance %s failed health checks", c.instance.Address)
+			}
+		}
+	}
+	for address := range p.checks {
+		if _, ok := p.conns[address]; !ok {
+			delete(p.checks, address)
+		}
+	}
+}
+
+// record passes the result of a live call to the outlier detector
+func (p *pool) record(instance *balancer.Instance, err error) {
+	p.lock.RLock()
+	detector, instances := p.detector, p.instances
+	p.lock.RUnlock()
+	if detector == nil {
+		return
+	}
+	if detector.Record(instance, err != nil && instanceFailureCodes[status.Code(err)], instances) {
+		logEjection(instance)
+	}
+}
+
+func outlierSettings(cfg conf.OutlierDetectionConfig) balancer.OutlierSettings {
+	return balancer.OutlierSettings{
+		ConsecutiveFailures: cfg.ConsecutiveFailures,
+		FailureRate:         float64(cfg.FailurePercent) / 100,
+		MinRequests:         cfg.GetMinRequests(),
+		BaseEjectionTime:    cfg.GetBaseEjectionTime(),
+		MaxEjectionTime:     cfg.GetMaxEjectionTime(),
+		MaxEjectedPercent:   cfg.GetMaxEjectedPercent(),
+	}
+}
+
+func logEjection(i *balancer.Instance) {
+	log.Warnf(log_code.WarnRouterInstanceHealth, "router instance %s is ejected as outlier", i.Address)
+	service.GetMetrics().UpdateRouterInstanceEjected(i.Address)
+}
+
+func reportInstances(instances []*balancer.Instance) {
+	metrics := service.GetMetrics()
+	if metrics == nil {
+		return
+	}
+	for _, i := range instances {
+		metrics.UpdateRouterInstance(i.Address, i.Available(), i.Outstanding())
+	}
+}
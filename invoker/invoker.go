@@ -1,30 +1,97 @@
 package invoker
 
 import (
-	"github.com/integration-system/isp-lib/backend"
+	"time"
+
+	"github.com/integration-system/isp-lib/proto/stubs"
 	"github.com/integration-system/isp-lib/structure"
 	log "github.com/integration-system/isp-log"
-	"google.golang.org/grpc"
+	"github.com/pkg/errors"
 	"isp-convert-service/conf"
 	"isp-convert-service/log_code"
+	"isp-convert-service/tlsutil"
 )
 
 var (
-	RouterClient = backend.NewRxGrpcClient(
-		backend.WithDialOptions(
-			grpc.WithInsecure(), grpc.WithBlock(),
-			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(int(conf.DefaultMaxResponseBodySize))),
-			grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(int(conf.DefaultMaxResponseBodySize))),
-		),
-		backend.WithDialingErrorHandler(func(err error) {
-			log.Errorf(log_code.ErrorRouterClientDialing, "router dialing err: %v", err)
-		}),
-	)
+	routerCredentials = tlsutil.NewClientCredentials()
+
+	routerPool = newPool(defaultClusterName, routerCredentials)
 )
 
 func HandleRoutesAddresses(list []structure.AddressConfiguration) bool {
-	if RouterClient.ReceiveAddressList(list) {
-		return true
+	return routerPool.update(list)
+}
+
+// ReceiveTransportConfiguration applies TLS settings to the router connections.
+// Rotated certificates are picked up by new handshakes, switching TLS on or off redials the router
+func ReceiveTransportConfiguration(cfg conf.GrpcTransportConfig) {
+	modeChanged, err := routerCredentials.Configure(tlsutil.NewClientSettings(cfg), tlsutil.LogReload("router"))
+	if err != nil {
+		log.Errorf(log_code.ErrorTlsConfiguration, "invalid router transport configuration, previous one stays in use: %v", err)
+		return
+	}
+	if modeChanged {
+		routerPool.redial()
+	}
+}
+
+// ReceiveBalancerConfiguration switches the algorithm of spreading calls between router instances
+func ReceiveBalancerConfiguration(cfg conf.BalancerConfig) error {
+	return routerPool.configure(cfg)
+}
+
+// Route describes the cluster chosen for the calls of a request
+type Route struct {
+	Cluster string
+	// Failover is set if the cluster is the standby one
+	Failover bool
+
+	syncTimeout   time.Duration
+	streamTimeout time.Duration
+}
+
+// SyncInvokeTimeout returns the timeout of the chosen cluster or the fallback one
+func (r Route) SyncInvokeTimeout(fallback time.Duration) time.Duration {
+	if r.syncTimeout > 0 {
+		return r.syncTimeout
 	}
-	return false
+	return fallback
+}
+
+// StreamInvokeTimeout returns the timeout of the chosen cluster or the fallback one
+func (r Route) StreamInvokeTimeout(fallback time.Duration) time.Duration {
+	if r.streamTimeout > 0 {
+		return r.streamTimeout
+	}
+	return fallback
+}
+
+// Conn returns the client of the named cluster, empty name means the cluster serving the method
+func Conn(method, cluster string) (isp.BackendServiceClient, Route, error) {
+	p, r := route(method, cluster)
+	if p.empty() {
+		return nil, r, errors.Errorf("router cluster %s is not available", r.Cluster)
+	}
+	return balancedClient{pool: p}, r, nil
+}
+
+// ShadowConn returns the client of the pool of the shadow cluster itself. Shadow calls never fail over,
+// so they neither reach the standby cluster nor change the failover state of other clusters
+func ShadowConn(cluster string) (isp.BackendServiceClient, error) {
+	clustersLock.RLock()
+	c, err := shadowCluster(cluster)
+	clustersLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if c.pool.empty() {
+		return nil, errors.Errorf("router cluster %s is not available", cluster)
+	}
+	return balancedClient{pool: c.pool}, nil
+}
+
+func Close() {
+	closeClusters()
+	routerPool.close()
+	routerCredentials.Close()
 }
@@ -0,0 +1,416 @@
+package invoker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/integration-system/isp-lib/proto/stubs"
+	"github.com/integration-system/isp-lib/structure"
+	log "github.com/integration-system/isp-log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/balancer"
+	"isp-convert-service/conf"
+	"isp-convert-service/log_code"
+	"isp-convert-service/service"
+	"isp-convert-service/tlsutil"
+)
+
+type routerConn struct {
+	instance *balancer.Instance
+	conn     *grpc.ClientConn
+	client   isp.BackendServiceClient
+	health   grpc_health_v1.HealthClient
+}
+
+func newRouterConn(instance *balancer.Instance, conn *grpc.ClientConn) *routerConn {
+	return &routerConn{
+		instance: instance,
+		conn:     conn,
+		client:   isp.NewBackendServiceClient(conn),
+		health:   grpc_health_v1.NewHealthClient(conn),
+	}
+}
+
+// pool keeps a connection per router instance and spreads calls between them by the configured picker
+type pool struct {
+	name        string
+	credentials *tlsutil.ClientCredentials
+
+	lock      sync.RWMutex
+	dial      conf.DialConfig
+	conns     map[string]*routerConn
+	addresses []string
+	settings  conf.BalancerConfig
+	picker    balancer.Picker
+	sticky    *balancer.Ring
+	instances []*balancer.Instance
+	detector  *balancer.OutlierDetector
+
+	checks map[string]*healthCounter
+	stop   chan struct{}
+	// failedOver is 1 while calls go to the standby cluster
+	failedOver int32
+	lastCheck  time.Time
+	lastSweep  time.Time
+}
+
+func newPool(name string, credentials *tlsutil.ClientCredentials) *pool {
+	return &pool{
+		name:        name,
+		credentials: credentials,
+		conns:       make(map[string]*routerConn),
+		checks:      make(map[string]*healthCounter),
+	}
+}
+
+// dialRouter must be called under the lock
+func (p *pool) dialRouter(address string) (*grpc.ClientConn, error) {
+	maxMessageSize := int(p.dial.GetMaxMessageSize())
+	opts := []grpc.DialOption{
+		p.credentials.DialOption(),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize)),
+		grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(maxMessageSize)),
+	}
+	if p.dial.KeepaliveTimeMs > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    time.Duration(p.dial.KeepaliveTimeMs) * time.Millisecond,
+			Timeout: p.dial.GetKeepaliveTimeout(),
+		}))
+	}
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		log.Errorf(log_code.ErrorRouterClientDialing, "router dialing err, cluster %s: %v", p.name, err)
+	}
+	return conn, err
+}
+
+// update dials new addresses and closes removed ones, instances of kept addresses keep their state
+func (p *pool) update(list []structure.AddressConfiguration) bool {
+	addresses := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for _, a := range list {
+		address := a.GetAddress()
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	removed := make([]*routerConn, 0)
+	for address, c := range p.conns {
+		if !seen[address] {
+			removed = append(removed, c)
+			delete(p.conns, address)
+		}
+	}
+	for _, address := range addresses {
+		if _, ok := p.conns[address]; ok {
+			continue
+		}
+		conn, err := p.dialRouter(address)
+		if err != nil {
+			continue
+		}
+		p.conns[address] = newRouterConn(balancer.NewInstance(address, p.settings.Weights[address]), conn)
+		go p.watchConnectivity(address, conn)
+	}
+	p.addresses = addresses
+	p.rebuild()
+	removeInstanceMetrics(removed)
+
+	closeConns(removed)
+	return len(p.conns) > 0
+}
+
+// redial replaces connections of all instances, it is used when TLS is switched on or off
+func (p *pool) redial() {
+	p.lock.Lock()
+	prev := p.redialLocked()
+	p.lock.Unlock()
+	closeConns(prev)
+}
+
+// setDial redials all instances if connection parameters are changed
+func (p *pool) setDial(dial conf.DialConfig) {
+	p.lock.Lock()
+	if p.dial == dial {
+		p.lock.Unlock()
+		return
+	}
+	p.dial = dial
+	prev := p.redialLocked()
+	p.lock.Unlock()
+	closeConns(prev)
+}
+
+// redialLocked must be called under the write lock, it returns replaced connections
+func (p *pool) redialLocked() []*routerConn {
+	prev := make([]*routerConn, 0, len(p.conns))
+	for address, c := range p.conns {
+		conn, err := p.dialRouter(address)
+		if err != nil {
+			continue
+		}
+		prev = append(prev, c)
+		p.conns[address] = newRouterConn(c.instance, conn)
+		go p.watchConnectivity(address, conn)
+	}
+	return prev
+}
+
+func (p *pool) balancerSettings() conf.BalancerConfig {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.settings
+}
+
+func (p *pool) configure(settings conf.BalancerConfig) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	prev := p.settings
+	p.settings = settings
+	if err := p.rebuild(); err != nil {
+		p.settings = prev
+		return err
+	}
+
+	p.detector = nil
+	if settings.OutlierDetection.Enable {
+		p.detector = balancer.NewOutlierDetector(outlierSettings(settings.OutlierDetection))
+	}
+	if !settings.HealthCheck.Enable {
+		for _, c := range p.conns {
+			c.instance.SetHealthy(true)
+		}
+		p.checks = make(map[string]*healthCounter)
+	}
+	if p.stop == nil {
+		p.stop = make(chan struct{})
+		go p.maintain(p.stop)
+	}
+	return nil
+}
+
+// rebuild must be called under the write lock, instances are replaced only if their weight is changed
+// because pickers read weights without locks
+func (p *pool) rebuild() error {
+	instances := make([]*balancer.Instance, 0, len(p.addresses))
+	for _, address := range p.addresses {
+		c, ok := p.conns[address]
+		if !ok {
+			continue
+		}
+		if instance := balancer.NewInstance(address, p.settings.Weights[address]); instance.Weight != c.instance.Weight {
+			instance.CopyState(c.instance)
+			c = &routerConn{instance: instance, conn: c.conn, client: c.client, health: c.health}
+			p.conns[address] = c
+		}
+		instances = append(instances, c.instance)
+	}
+	picker, err := balancer.New(p.settings.Algorithm, instances, balancer.Options{VirtualNodes: p.settings.VirtualNodes})
+	if err != nil {
+		return err
+	}
+	p.picker = picker
+	p.instances = instances
+	p.sticky = nil
+	if p.settings.StickyHeader != "" && len(instances) > 0 {
+		p.sticky = balancer.NewRing(instances, p.settings.VirtualNodes, picker)
+	}
+	return nil
+}
+
+// watchConnectivity marks the instance unavailable while its connection is in transient failure,
+// it stops when the connection is closed on removal or redial
+func (p *pool) watchConnectivity(address string, conn *grpc.ClientConn) {
+	for {
+		state := conn.GetState()
+		if state == connectivity.Shutdown {
+			return
+		}
+		p.lock.RLock()
+		if c, ok := p.conns[address]; ok && c.conn == conn {
+			c.instance.SetConnected(state != connectivity.TransientFailure)
+		}
+		p.lock.RUnlock()
+		if !conn.WaitForStateChange(context.Background(), state) {
+			return
+		}
+	}
+}
+
+func metadataValue(ctx context.Context, key string) string {
+	if key == "" {
+		return ""
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (p *pool) pick(ctx context.Context) (*routerConn, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.picker == nil {
+		return nil, status.Error(codes.Unavailable, "no router instances available")
+	}
+	var instance *balancer.Instance
+	if key := metadataValue(ctx, p.settings.StickyHeader); p.sticky != nil && key != "" {
+		instance = p.sticky.Pick(key)
+	} else {
+		instance = p.picker.Pick(metadataValue(ctx, p.settings.HashHeader))
+	}
+	if role, ok := ctx.Value(hedgeKey{}).(hedgeRole); ok {
+		instance = role.apply(instance, p.instances)
+	}
+	return p.conns[instance.Address], nil
+}
+
+type hedgeKey struct{}
+
+// hedgePick is the instance picked for the primary call of a hedged request
+type hedgePick struct {
+	lock    sync.Mutex
+	address string
+}
+
+type hedgeRole struct {
+	pick  *hedgePick
+	avoid bool
+}
+
+// HedgeContexts returns contexts for the primary and the hedged call of a request. Sticky and hashing
+// pickers choose the same instance for both calls, so the hedged one goes to another available instance
+func HedgeContexts(ctx context.Context) (context.Context, context.Context) {
+	pick := &hedgePick{}
+	return context.WithValue(ctx, hedgeKey{}, hedgeRole{pick: pick}),
+		context.WithValue(ctx, hedgeKey{}, hedgeRole{pick: pick, avoid: true})
+}
+
+func (r hedgeRole) apply(instance *balancer.Instance, all []*balancer.Instance) *balancer.Instance {
+	r.pick.lock.Lock()
+	defer r.pick.lock.Unlock()
+	if !r.avoid {
+		r.pick.address = instance.Address
+		return instance
+	}
+	if instance.Address != r.pick.address {
+		return instance
+	}
+	for _, i := range all {
+		if i.Address != r.pick.address && i.Available() {
+			return i
+		}
+	}
+	return instance
+}
+
+func (p *pool) empty() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return len(p.conns) == 0
+}
+
+// available reports whether at least one instance is connected, healthy and not ejected
+func (p *pool) available() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, i := range p.instances {
+		if i.Available() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pool) close() {
+	p.lock.Lock()
+	conns := make([]*routerConn, 0, len(p.conns))
+	for _, c := range p.conns {
+		conns = append(conns, c)
+	}
+	p.conns = make(map[string]*routerConn)
+	p.addresses = nil
+	p.picker = nil
+	p.instances = nil
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	removeInstanceMetrics(conns)
+	p.lock.Unlock()
+	closeConns(conns)
+}
+
+// removeInstanceMetrics must be called under the write lock, so the maintenance loop doesn't report removed instances again
+func removeInstanceMetrics(conns []*routerConn) {
+	metrics := service.GetMetrics()
+	if metrics == nil {
+		return
+	}
+	for _, c := range conns {
+		metrics.RemoveRouterInstance(c.instance.Address)
+	}
+}
+
+func closeConns(conns []*routerConn) {
+	for _, c := range conns {
+		if err := c.conn.Close(); err != nil {
+			log.Warnf(log_code.ErrorRouterClientDialing, "close router connection %s: %v", c.instance.Address, err)
+		}
+	}
+}
+
+// balancedClient picks a router instance for every call
+type balancedClient struct {
+	pool *pool
+}
+
+func (c balancedClient) Request(ctx context.Context, in *isp.Message, opts ...grpc.CallOption) (*isp.Message, error) {
+	rc, err := c.pool.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rc.instance.Begin()
+	defer rc.instance.End()
+	response, err := rc.client.Request(ctx, in, opts...)
+	c.pool.record(rc.instance, err)
+	return response, err
+}
+
+// RequestStream counts the stream as outstanding until its context is done
+func (c balancedClient) RequestStream(ctx context.Context, opts ...grpc.CallOption) (isp.BackendService_RequestStreamClient, error) {
+	rc, err := c.pool.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rc.instance.Begin()
+	stream, err := rc.client.RequestStream(ctx, opts...)
+	c.pool.record(rc.instance, err)
+	if err != nil {
+		rc.instance.End()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		rc.instance.End()
+	}()
+	return stream, nil
+}
@@ -0,0 +1,49 @@
+package invoker
+
+import (
+	"testing"
+
+	"github.com/integration-system/isp-lib/structure"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+	"isp-convert-service/balancer"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+	"isp-convert-service/tlsutil"
+)
+
+func TestPool_PickHedged(t *testing.T) {
+	service.InitMetrics()
+	p := newPool("test", tlsutil.NewClientCredentials())
+	defer p.close()
+	if err := p.configure(conf.BalancerConfig{Algorithm: balancer.ConsistentHash, HashHeader: "x-tenant-id"}); err != nil {
+		t.Fatal(err)
+	}
+	p.update([]structure.AddressConfiguration{{IP: "127.0.0.1", Port: "9001"}, {IP: "127.0.0.1", Port: "9002"}})
+
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("x-tenant-id", "tenant"))
+	plain, err := p.pick(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := p.pick(ctx); again != plain {
+		t.Fatal("consistent hash must pick the same instance for the same key")
+	}
+
+	primaryCtx, hedgedCtx := HedgeContexts(ctx)
+	primary, _ := p.pick(primaryCtx)
+	hedged, _ := p.pick(hedgedCtx)
+	if primary != plain {
+		t.Error("primary call must keep the instance of the key")
+	}
+	if hedged == primary {
+		t.Error("hedged call must go to another instance")
+	}
+
+	setAvailable(p, false)
+	primaryCtx, hedgedCtx = HedgeContexts(ctx)
+	primary, _ = p.pick(primaryCtx)
+	if hedged, _ = p.pick(hedgedCtx); hedged != primary {
+		t.Error("hedged call must keep the instance if no other one is available")
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"github.com/integration-system/isp-lib/structure"
 	log "github.com/integration-system/isp-log"
 	"google.golang.org/grpc"
+	"isp-convert-service/conf"
 	"isp-convert-service/log_code"
+	"isp-convert-service/tlsutil"
 )
 
 var (
+	journalCredentials   = tlsutil.NewClientCredentials()
 	journalServiceClient = backend.NewRxGrpcClient(
-		backend.WithDialOptions(grpc.WithInsecure(), grpc.WithBlock()),
+		backend.WithDialOptions(grpc.WithTransportCredentials(journalCredentials), grpc.WithBlock()),
 		backend.WithDialingErrorHandler(func(err error) {
 			log.Warnf(log_code.WarnJournalClientDialing, "journal client dialing err: %v", err)
 		}),
@@ -29,3 +32,16 @@ func ReceiveJournalServiceAddressList(list []structure.AddressConfiguration) boo
 
 	return true
 }
+
+// ReceiveTransportConfiguration applies TLS settings to the journal connections.
+// The journal client can't be recreated, so switching TLS on or off takes effect after reconnection
+func ReceiveTransportConfiguration(cfg conf.GrpcTransportConfig) {
+	modeChanged, err := journalCredentials.Configure(tlsutil.NewClientSettings(cfg), tlsutil.LogReload("journal"))
+	if err != nil {
+		log.Errorf(log_code.ErrorTlsConfiguration, "invalid journal transport configuration, previous one stays in use: %v", err)
+		return
+	}
+	if modeChanged {
+		log.Warnf(log_code.WarnJournalClientDialing, "journal transport mode changed, it will be applied to new connections")
+	}
+}
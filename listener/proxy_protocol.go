@@ -0,0 +1,229 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+
+	v1Prefix       = "PROXY "
+	v1MaxLength    = 107
+	v2HeaderLength = 16
+
+	v2CmdLocal = 0x0
+	v2CmdProxy = 0x1
+	v2FamTcp4  = 0x11
+	v2FamTcp6  = 0x21
+)
+
+var (
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// ProxyProtocolListener accepts PROXY protocol v1 and v2 headers from trusted peers.
+// Connections from other peers and connections without a header are passed as is
+type ProxyProtocolListener struct {
+	net.Listener
+	trusted func(ip net.IP) bool
+}
+
+func NewProxyProtocolListener(ln net.Listener, trusted func(ip net.IP) bool) net.Listener {
+	return &ProxyProtocolListener{Listener: ln, trusted: trusted}
+}
+
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !l.trusted(tcpAddr.IP) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn reads the header lazily on first use, so a slow peer does not block Accept
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	once       sync.Once
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	err        error
+
+	deadlineLock sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline and SetReadDeadline remember the deadline requested by the server
+// to restore it after the header has been read with its own timeout
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	c.readDeadline = t
+	c.deadlineLock.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	c.readDeadline = t
+	c.deadlineLock.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer func() {
+		c.deadlineLock.Lock()
+		_ = c.Conn.SetReadDeadline(c.readDeadline)
+		c.deadlineLock.Unlock()
+	}()
+
+	c.remoteAddr, c.localAddr, c.err = ReadProxyHeader(c.reader)
+	if c.err != nil {
+		_ = c.Conn.Close()
+	}
+}
+
+// ReadProxyHeader consumes a PROXY protocol header if the stream starts with one.
+// Nil addresses without error mean that there is no header or it carries no address (LOCAL, UNKNOWN)
+func ReadProxyHeader(r *bufio.Reader) (src net.Addr, dst net.Addr, err error) {
+	if prefix, err := r.Peek(len(v1Prefix)); err == nil && string(prefix) == v1Prefix {
+		return readV1(r)
+	}
+	if prefix, err := r.Peek(len(v2Signature)); err == nil && bytes.Equal(prefix, v2Signature) {
+		return readV2(r)
+	}
+	return nil, nil, nil
+}
+
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	line := make([]byte, 0, v1MaxLength)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "read proxy protocol v1 header")
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return nil, nil, errors.New("proxy protocol v1 header is too long")
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, nil, errors.New("proxy protocol v1 header is not terminated with CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.Errorf("invalid proxy protocol v1 header '%s'", strings.TrimSpace(string(line)))
+	}
+	src, err := parseV1Address(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Address(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseV1Address(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.Errorf("invalid proxy protocol v1 address '%s'", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.Errorf("invalid proxy protocol v1 port '%s'", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, errors.Wrap(err, "read proxy protocol v2 header")
+	}
+	if header[12]>>4 != 0x2 {
+		return nil, nil, errors.Errorf("unsupported proxy protocol version %d", header[12]>>4)
+	}
+	command := header[12] & 0x0F
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, errors.Wrap(err, "read proxy protocol v2 addresses")
+	}
+
+	switch command {
+	case v2CmdLocal:
+		return nil, nil, nil
+	case v2CmdProxy:
+	default:
+		return nil, nil, errors.Errorf("unsupported proxy protocol v2 command %d", command)
+	}
+
+	var ipLen int
+	switch family {
+	case v2FamTcp4:
+		ipLen = net.IPv4len
+	case v2FamTcp6:
+		ipLen = net.IPv6len
+	default:
+		// UDP and unix sockets are not relevant for an HTTP listener
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, errors.New("proxy protocol v2 address block is too short")
+	}
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return src, dst, nil
+}
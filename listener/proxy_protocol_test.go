@@ -0,0 +1,103 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+var (
+	proxyHeaderCases = []struct {
+		Name    string
+		Input   []byte
+		Src     string
+		Payload string
+		Error   bool
+	}{
+		{Name: "no header", Input: []byte("GET / HTTP/1.1\r\n"), Payload: "GET / HTTP/1.1\r\n"},
+		{Name: "v1 tcp4", Input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nGET /"), Src: "192.168.0.1:56324", Payload: "GET /"},
+		{Name: "v1 tcp6", Input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET /"), Src: "[2001:db8::1]:56324", Payload: "GET /"},
+		{Name: "v1 unknown", Input: []byte("PROXY UNKNOWN\r\nGET /"), Payload: "GET /"},
+		{Name: "v1 without crlf", Input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\nGET /"), Error: true},
+		{Name: "v1 invalid address", Input: []byte("PROXY TCP4 host 10.0.0.1 56324 443\r\n"), Error: true},
+		{
+			Name: "v2 tcp4",
+			Input: append(append(append([]byte{}, v2Signature...),
+				0x21, 0x11, 0x00, 0x0C,
+				192, 168, 0, 1, 10, 0, 0, 1, 0xDC, 0x04, 0x01, 0xBB,
+			), []byte("GET /")...),
+			Src:     "192.168.0.1:56324",
+			Payload: "GET /",
+		},
+		{
+			Name:    "v2 local",
+			Input:   append(append(append([]byte{}, v2Signature...), 0x20, 0x00, 0x00, 0x00), []byte("GET /")...),
+			Payload: "GET /",
+		},
+		{
+			Name:  "v2 short address block",
+			Input: append(append([]byte{}, v2Signature...), 0x21, 0x11, 0x00, 0x04, 192, 168, 0, 1),
+			Error: true,
+		},
+	}
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, c := range proxyHeaderCases {
+		r := bufio.NewReader(bytes.NewReader(c.Input))
+		src, _, err := ReadProxyHeader(r)
+		if c.Error {
+			if err == nil {
+				t.Errorf("%s: expected error", c.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.Name, err)
+			continue
+		}
+		if c.Src == "" && src != nil {
+			t.Errorf("%s: expected no source address, got %s", c.Name, src)
+		}
+		if c.Src != "" && (src == nil || src.String() != c.Src) {
+			t.Errorf("%s: expected source address %s, got %v", c.Name, c.Src, src)
+		}
+		rest, _ := ioutil.ReadAll(r)
+		if string(rest) != c.Payload {
+			t.Errorf("%s: expected payload %q, got %q", c.Name, c.Payload, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener_Accept(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pl := NewProxyProtocolListener(ln, func(ip net.IP) bool { return ip.IsLoopback() })
+
+	go func() {
+		conn, err := net.Dial("tcp4", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 80\r\nping"))
+	}()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if addr := conn.RemoteAddr().String(); addr != "203.0.113.7:40000" {
+		t.Errorf("expected remote address from proxy header, got %s", addr)
+	}
+	data, _ := ioutil.ReadAll(conn)
+	if string(data) != "ping" {
+		t.Errorf("expected payload after proxy header, got %q", data)
+	}
+}
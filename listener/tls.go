@@ -0,0 +1,122 @@
+package listener
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"isp-convert-service/conf"
+	"isp-convert-service/tlsutil"
+)
+
+var (
+	watcherLock sync.Mutex
+	watcher     *tlsutil.Watcher
+)
+
+type certificateStore struct {
+	defaultPair *tlsutil.KeyPair
+	sni         map[string]*tlsutil.KeyPair
+}
+
+func (s *certificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if pair, ok := s.sni[name]; ok {
+			return pair.Certificate(), nil
+		}
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if pair, ok := s.sni["*"+name[i:]]; ok {
+				return pair.Certificate(), nil
+			}
+		}
+	}
+	if s.defaultPair == nil {
+		return nil, errors.Errorf("no certificate for server name '%s'", hello.ServerName)
+	}
+	return s.defaultPair.Certificate(), nil
+}
+
+// NewServerTlsConfig loads certificates and starts watching their files.
+// The watcher of the previous configuration is stopped
+func NewServerTlsConfig(cfg conf.TlsConfig) (*tls.Config, error) {
+	minVersion, err := tlsutil.ParseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := tlsutil.ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]tlsutil.Reloadable, 0, len(cfg.Sni)+1)
+	store := &certificateStore{sni: make(map[string]*tlsutil.KeyPair, len(cfg.Sni))}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		store.defaultPair, err = tlsutil.NewKeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, store.defaultPair)
+	}
+	for name, files := range cfg.Sni {
+		pair, err := tlsutil.NewKeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		store.sni[strings.ToLower(name)] = pair
+		resources = append(resources, pair)
+	}
+	if len(resources) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:               minVersion,
+		CipherSuites:             cipherSuites,
+		PreferServerCipherSuites: len(cipherSuites) > 0,
+		GetCertificate:           store.GetCertificate,
+	}
+
+	if cfg.ClientAuth.Enable {
+		if len(cfg.ClientAuth.CaFiles) == 0 {
+			return nil, errors.New("no ca files configured for client authentication")
+		}
+		clientCAs, err := tlsutil.NewCertPool(cfg.ClientAuth.CaFiles...)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, clientCAs)
+		if len(cfg.ClientAuth.RequiredMethodsPatterns) > 0 {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		// ClientCAs can't be swapped in place, so every handshake gets a copy with the actual pool
+		baseConfig := tlsConfig.Clone()
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := baseConfig.Clone()
+			config.ClientCAs = clientCAs.Pool()
+			return config, nil
+		}
+	}
+
+	replaceWatcher(tlsutil.NewWatcher(cfg.GetCertificateReloadPeriod(), tlsutil.LogReload("http listener"), resources...))
+	return tlsConfig, nil
+}
+
+func StopWatching() {
+	replaceWatcher(nil)
+}
+
+func replaceWatcher(w *tlsutil.Watcher) {
+	watcherLock.Lock()
+	defer watcherLock.Unlock()
+	if watcher != nil {
+		watcher.Stop()
+	}
+	watcher = w
+	if watcher != nil {
+		watcher.Start()
+	}
+}
@@ -0,0 +1,95 @@
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"isp-convert-service/tlsutil"
+)
+
+func writeKeyPair(t *testing.T, dir, name string) *tlsutil.KeyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tlsutil.NewKeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func TestCertificateStore_GetCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &certificateStore{
+		defaultPair: writeKeyPair(t, dir, "default.local"),
+		sni: map[string]*tlsutil.KeyPair{
+			"api.example.com": writeKeyPair(t, dir, "api.example.com"),
+			"*.example.org":   writeKeyPair(t, dir, "wildcard.example.org"),
+		},
+	}
+	cases := []struct {
+		serverName string
+		expected   string
+	}{
+		{serverName: "api.example.com", expected: "api.example.com"},
+		{serverName: "API.Example.com.", expected: "api.example.com"},
+		{serverName: "static.example.org", expected: "wildcard.example.org"},
+		{serverName: "a.b.example.org", expected: "default.local"},
+		{serverName: "other.example.com", expected: "default.local"},
+		{serverName: "", expected: "default.local"},
+	}
+	for _, c := range cases {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: c.serverName})
+		if err != nil {
+			t.Fatalf("%s: %v", c.serverName, err)
+		}
+		if cert.Leaf.Subject.CommonName != c.expected {
+			t.Errorf("%s: expected certificate %s, got %s", c.serverName, c.expected, cert.Leaf.Subject.CommonName)
+		}
+	}
+
+	store.defaultPair = nil
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.local"}); err == nil {
+		t.Fatal("expected error without default certificate")
+	}
+}
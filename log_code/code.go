@@ -9,4 +9,23 @@ const (
 	ErrorRouterClientDialing                   = 606
 	WarnJournalCouldNotWriteToFile             = 607
 	WarnJournalClientDialing                   = 608
+	WarnInvalidTrustedProxies                  = 609
+	WarnTlsCertificateReload                   = 610
+	InfoTlsCertificateReloaded                 = 611
+	ErrorTlsConfiguration                      = 612
+	WarnJwksRefresh                            = 613
+	ErrorAuthConfiguration                     = 614
+	ErrorRateLimitConfiguration                = 615
+	ErrorAdminServer                           = 616
+	ErrorQuotaStore                            = 617
+	ErrorMetering                              = 618
+	ErrorBalancerConfiguration                 = 619
+	WarnRouterInstanceHealth                   = 620
+	WarnRouterFailover                         = 621
+	WarnMirrorMismatch                         = 622
+	ErrorQuotaConfiguration                    = 623
+	ErrorBulkheadConfiguration                 = 624
+	ErrorAdaptiveLimitConfiguration            = 625
+	ErrorPriorityConfiguration                 = 626
+	ErrorRetryConfiguration                    = 627
 )
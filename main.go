@@ -1,12 +1,31 @@
 package main
 
 import (
+	"crypto/tls"
 	"github.com/integration-system/isp-lib/config/schema"
 	"github.com/integration-system/isp-lib/structure"
+	"isp-convert-service/acl"
+	"isp-convert-service/adaptive"
+	"isp-convert-service/admin"
+	"isp-convert-service/auth"
+	"isp-convert-service/breaker"
+	"isp-convert-service/bulkhead"
+	"isp-convert-service/canary"
 	"isp-convert-service/controllers"
+	"isp-convert-service/cors"
+	"isp-convert-service/hedge"
 	"isp-convert-service/journal"
+	"isp-convert-service/listener"
 	"isp-convert-service/log_code"
+	"isp-convert-service/metering"
+	"isp-convert-service/mirror"
+	"isp-convert-service/priority"
+	"isp-convert-service/quota"
+	"isp-convert-service/ratelimit"
+	"isp-convert-service/realip"
+	"isp-convert-service/retry"
 	"isp-convert-service/service"
+	"net"
 	"os"
 	"sync"
 	"time"
@@ -51,10 +70,70 @@ func onLocalConfigLoad(cfg *conf.Configuration) {
 
 func onRemoteConfigReceive(cfg, oldRemoteConfig *conf.RemoteConfig) {
 	localCfg := config.Get().(*conf.Configuration)
+	journal.ReceiveTransportConfiguration(cfg.JournalTransport)
 	journal.Client.ReceiveConfiguration(cfg.Journal, localCfg.ModuleName)
+	invoker.ReceiveTransportConfiguration(cfg.RouterTransport)
+	if err := invoker.ReceiveBalancerConfiguration(cfg.RouterBalancer); err != nil {
+		log.Errorf(log_code.ErrorBalancerConfiguration, "invalid router balancer configuration, previous one stays in use: %v", err)
+	}
+	if err := invoker.ReceiveClustersConfiguration(cfg.RouterClusters, cfg.RouterFailover); err != nil {
+		log.Errorf(log_code.ErrorBalancerConfiguration, "invalid router clusters, previous ones stay in use: %v", err)
+	}
+	if err := canary.ReceiveConfiguration(cfg.RouterSplits); err != nil {
+		log.Errorf(log_code.ErrorBalancerConfiguration, "invalid router traffic splits, previous ones stay in use: %v", err)
+	}
+	if err := mirror.ReceiveConfiguration(cfg.RouterMirrors); err != nil {
+		log.Errorf(log_code.ErrorBalancerConfiguration, "invalid router mirrors, previous ones stay in use: %v", err)
+	}
 
 	service.JournalMethodsMatcher = service.NewCacheableMethodMatcher(cfg.JournalingMethodsPatterns)
 
+	trustedProxies, err := realip.ParseNetworks(cfg.ClientAddress.TrustedProxies)
+	if err != nil {
+		log.Warnf(log_code.WarnInvalidTrustedProxies, "invalid trusted proxies, forwarding headers will be ignored: %v", err)
+	}
+	realip.SetTrustedProxies(trustedProxies)
+	auth.ReceiveClientAuthConfiguration(cfg.Tls)
+	if err := cors.ReceiveConfiguration(cfg.Cors); err != nil {
+		log.Errorf(log_code.ErrorAuthConfiguration, "invalid cors configuration, previous one stays in use: %v", err)
+	}
+	if err := acl.ReceiveConfiguration(cfg.IpAccessRules); err != nil {
+		log.Errorf(log_code.ErrorAuthConfiguration, "invalid ip access rules, previous ones stay in use: %v", err)
+	}
+	auth.ReceiveJwtConfiguration(cfg.Jwt)
+	if err := auth.ReceiveHmacConfiguration(cfg.Hmac); err != nil {
+		log.Errorf(log_code.ErrorAuthConfiguration, "invalid hmac configuration, previous one stays in use: %v", err)
+	}
+	if err := auth.ReceiveApiKeysConfiguration(cfg.ApiKeys); err != nil {
+		log.Errorf(log_code.ErrorAuthConfiguration, "invalid api keys configuration, previous one stays in use: %v", err)
+	}
+	if err := ratelimit.ReceiveConfiguration(cfg.RateLimits); err != nil {
+		log.Errorf(log_code.ErrorRateLimitConfiguration, "invalid rate limits, previous ones stay in use: %v", err)
+	}
+	if err := bulkhead.ReceiveConfiguration(cfg.Bulkheads); err != nil {
+		log.Errorf(log_code.ErrorBulkheadConfiguration, "invalid bulkheads, previous ones stay in use: %v", err)
+	}
+	if err := adaptive.ReceiveConfiguration(cfg.AdaptiveLimit); err != nil {
+		log.Errorf(log_code.ErrorAdaptiveLimitConfiguration, "invalid adaptive limit, previous one stays in use: %v", err)
+	}
+	if err := priority.ReceiveConfiguration(cfg.Priority); err != nil {
+		log.Errorf(log_code.ErrorPriorityConfiguration, "invalid priority configuration, previous one stays in use: %v", err)
+	}
+	breaker.ReceiveConfiguration(cfg.CircuitBreaker)
+	if err := retry.ReceiveConfiguration(cfg.Retries); err != nil {
+		log.Errorf(log_code.ErrorRetryConfiguration, "invalid retry policies, previous ones stay in use: %v", err)
+	}
+	hedge.ReceiveConfiguration(cfg.Hedging)
+	if err := quota.ReceiveConfiguration(cfg.Quotas); err != nil {
+		log.Errorf(log_code.ErrorQuotaConfiguration, "invalid quotas configuration, previous one stays in use: %v", err)
+	}
+	if err := metering.ReceiveConfiguration(cfg.Metering); err != nil {
+		log.Errorf(log_code.ErrorMetering, "invalid metering configuration, previous one stays in use: %v", err)
+	}
+	if err := admin.ReceiveConfiguration(cfg.Admin); err != nil {
+		log.Errorf(log_code.ErrorAdminServer, "invalid admin configuration, previous one stays in use: %v", err)
+	}
+
 	createRestServer(cfg)
 	metric.InitCollectors(cfg.Metrics, oldRemoteConfig.Metrics)
 	metric.InitHttpServer(cfg.Metrics)
@@ -68,9 +147,21 @@ func createRestServer(appConfig *conf.RemoteConfig) {
 	// === REST ===
 	router.Handle("POST", "/api/*any", controllers.HandlerAllRequest)
 	router.Handle("GET", "/api/*any", controllers.HandlerAllRequest)
+	router.Handle("OPTIONS", "/api/*any", controllers.HandlePreflight)
 
 	maxRequestBodySize := appConfig.GetMaxRequestBodySize()
 
+	var tlsConfig *tls.Config
+	if appConfig.Tls.Enable {
+		var err error
+		if tlsConfig, err = listener.NewServerTlsConfig(appConfig.Tls); err != nil {
+			log.Errorf(log_code.ErrorTlsConfiguration, "invalid tls configuration, http server is not restarted: %v", err)
+			return
+		}
+	} else {
+		listener.StopWatching()
+	}
+
 	srvLock.Lock()
 
 	if httpSrv != nil {
@@ -87,11 +178,22 @@ func createRestServer(appConfig *conf.RemoteConfig) {
 		ReadTimeout:        time.Second * 60,
 		MaxRequestBodySize: int(maxRequestBodySize),
 	}
-	go func() {
-		if err := httpSrv.ListenAndServe(restAddress); err != nil {
+	go func(srv *fasthttp.Server) {
+		ln, err := net.Listen("tcp4", restAddress)
+		if err != nil {
+			log.Error(log_code.ErrorCreateRestServerHttpSrvListenAndServe, err)
+			return
+		}
+		if appConfig.ClientAddress.EnableProxyProtocol {
+			ln = listener.NewProxyProtocolListener(ln, realip.IsTrustedProxy)
+		}
+		if tlsConfig != nil {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		if err := srv.Serve(ln); err != nil {
 			log.Error(log_code.ErrorCreateRestServerHttpSrvListenAndServe, err)
 		}
-	}()
+	}(httpSrv)
 
 	srvLock.Unlock()
 }
@@ -111,7 +213,11 @@ func socketConfiguration(cfg interface{}) structure.SocketConfiguration {
 
 func onShutdown(_ context.Context, _ os.Signal) {
 	_ = httpSrv.Shutdown()
-	invoker.RouterClient.Close()
+	listener.StopWatching()
+	admin.Shutdown()
+	quota.Close()
+	metering.Close()
+	invoker.Close()
 }
 
 func routesData(localConfig interface{}) bootstrap.ModuleInfo {
@@ -0,0 +1,352 @@
+package metering
+
+import (
+	"bufio"
+	"encoding/csv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/integration-system/isp-log"
+	"github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"isp-convert-service/admin"
+	"isp-convert-service/conf"
+	"isp-convert-service/log_code"
+)
+
+const (
+	FormatCsv   = "csv"
+	FormatJsonl = "jsonl"
+
+	fileDateLayout = "2006-01-02"
+)
+
+var (
+	json = jsoniter.ConfigFastest
+
+	csvHeader = []string{"from", "to", "application", "method", "count", "errors", "bytesIn", "bytesOut", "latencyMs"}
+
+	meter     *Meter
+	meterLock sync.RWMutex
+)
+
+func init() {
+	admin.Handle("GET", "/usage", handleUsage)
+}
+
+type key struct {
+	application string
+	method      string
+}
+
+type Counters struct {
+	Count     int64 `json:"count"`
+	Errors    int64 `json:"errors"`
+	BytesIn   int64 `json:"bytesIn"`
+	BytesOut  int64 `json:"bytesOut"`
+	LatencyMs int64 `json:"latencyMs"`
+}
+
+func (c *Counters) add(other Counters) {
+	c.Count += other.Count
+	c.Errors += other.Errors
+	c.BytesIn += other.BytesIn
+	c.BytesOut += other.BytesOut
+	c.LatencyMs += other.LatencyMs
+}
+
+type Row struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Application string    `json:"application"`
+	Method      string    `json:"method"`
+	Counters
+}
+
+// Meter aggregates usage per application and method, the current period is flushed to files and added to totals.
+// Totals are kept for the day of the current file only, so they don't grow with every method ever called
+type Meter struct {
+	header    string
+	directory string
+	format    string
+
+	lock        sync.Mutex
+	period      map[key]*Counters
+	periodStart time.Time
+	totals      map[key]*Counters
+	totalsDay   string
+	since       time.Time
+	now         func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMeter(cfg conf.MeteringConfig) (*Meter, error) {
+	format := strings.ToLower(cfg.GetFormat())
+	if format != FormatCsv && format != FormatJsonl {
+		return nil, errors.Errorf("unsupported metering format '%s'", cfg.Format)
+	}
+	now := time.Now()
+	return &Meter{
+		header:      cfg.GetHeader(),
+		directory:   cfg.GetDirectory(),
+		format:      format,
+		period:      make(map[key]*Counters),
+		periodStart: now,
+		totals:      make(map[key]*Counters),
+		totalsDay:   fileDay(now),
+		since:       now,
+		now:         time.Now,
+	}, nil
+}
+
+func (m *Meter) Record(application, method string, status int, bytesIn, bytesOut int64, latency time.Duration) {
+	c := Counters{Count: 1, BytesIn: bytesIn, BytesOut: bytesOut, LatencyMs: int64(latency / time.Millisecond)}
+	if status >= http.StatusBadRequest {
+		c.Errors = 1
+	}
+	k := key{application: application, method: method}
+
+	m.lock.Lock()
+	counters, ok := m.period[k]
+	if !ok {
+		counters = &Counters{}
+		m.period[k] = counters
+	}
+	counters.add(c)
+	m.lock.Unlock()
+}
+
+// Flush appends the current period to the file of the day the period ends in
+func (m *Meter) Flush() error {
+	m.lock.Lock()
+	period, from, to := m.period, m.periodStart, m.now()
+	m.period = make(map[key]*Counters, len(period))
+	m.periodStart = to
+	if day := fileDay(to); day != m.totalsDay {
+		m.totals = make(map[key]*Counters, len(m.totals))
+		m.totalsDay = day
+		m.since = from
+	}
+	for k, c := range period {
+		total, ok := m.totals[k]
+		if !ok {
+			total = &Counters{}
+			m.totals[k] = total
+		}
+		total.add(*c)
+	}
+	m.lock.Unlock()
+
+	if len(period) == 0 {
+		return nil
+	}
+	return m.write(toRows(period, from, to), to)
+}
+
+func fileDay(t time.Time) string {
+	return t.UTC().Format(fileDateLayout)
+}
+
+func (m *Meter) write(rows []Row, now time.Time) error {
+	if err := os.MkdirAll(m.directory, 0755); err != nil {
+		return errors.Wrap(err, "create metering dir")
+	}
+	file := filepath.Join(m.directory, "usage-"+fileDay(now)+"."+m.format)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "open metering file %s", file)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat metering file %s", file)
+	}
+
+	w := bufio.NewWriter(f)
+	if m.format == FormatCsv {
+		cw := csv.NewWriter(w)
+		if info.Size() == 0 {
+			_ = cw.Write(csvHeader)
+		}
+		for _, r := range rows {
+			_ = cw.Write([]string{
+				r.From.UTC().Format(time.RFC3339),
+				r.To.UTC().Format(time.RFC3339),
+				r.Application,
+				r.Method,
+				strconv.FormatInt(r.Count, 10),
+				strconv.FormatInt(r.Errors, 10),
+				strconv.FormatInt(r.BytesIn, 10),
+				strconv.FormatInt(r.BytesOut, 10),
+				strconv.FormatInt(r.LatencyMs, 10),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return errors.Wrapf(err, "write metering file %s", file)
+		}
+	} else {
+		for _, r := range rows {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return errors.Wrap(err, "marshal metering row")
+			}
+			_, _ = w.Write(data)
+			_ = w.WriteByte('\n')
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrapf(err, "write metering file %s", file)
+	}
+	return nil
+}
+
+// Totals returns usage since the meter is started or the day is changed, including the period which is not flushed yet
+func (m *Meter) Totals() ([]Row, time.Time) {
+	m.lock.Lock()
+	merged := make(map[key]*Counters, len(m.totals))
+	for k, c := range m.totals {
+		copied := *c
+		merged[k] = &copied
+	}
+	for k, c := range m.period {
+		total, ok := merged[k]
+		if !ok {
+			total = &Counters{}
+			merged[k] = total
+		}
+		total.add(*c)
+	}
+	since := m.since
+	m.lock.Unlock()
+	return toRows(merged, since, m.now()), since
+}
+
+func (m *Meter) start(period time.Duration) {
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				if err := m.Flush(); err != nil {
+					log.Errorf(log_code.ErrorMetering, "could not flush usage: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (m *Meter) close() {
+	close(m.stop)
+	<-m.done
+	if err := m.Flush(); err != nil {
+		log.Errorf(log_code.ErrorMetering, "could not flush usage: %v", err)
+	}
+}
+
+func toRows(counters map[key]*Counters, from, to time.Time) []Row {
+	rows := make([]Row, 0, len(counters))
+	for k, c := range counters {
+		rows = append(rows, Row{From: from, To: to, Application: k.application, Method: k.method, Counters: *c})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Application != rows[j].Application {
+			return rows[i].Application < rows[j].Application
+		}
+		return rows[i].Method < rows[j].Method
+	})
+	return rows
+}
+
+// ReceiveConfiguration flushes the previous meter, so totals on the admin endpoint restart with new configuration
+func ReceiveConfiguration(cfg conf.MeteringConfig) error {
+	var next *Meter
+	if cfg.Enable {
+		var err error
+		if next, err = NewMeter(cfg); err != nil {
+			return err
+		}
+		next.start(cfg.GetFlushPeriod())
+	}
+
+	meterLock.Lock()
+	prev := meter
+	meter = next
+	meterLock.Unlock()
+	if prev != nil {
+		prev.close()
+	}
+	return nil
+}
+
+func Close() {
+	meterLock.Lock()
+	prev := meter
+	meter = nil
+	meterLock.Unlock()
+	if prev != nil {
+		prev.close()
+	}
+}
+
+// Record accounts the finished request to the application from the configured header
+func Record(ctx *fasthttp.RequestCtx, method string, latency time.Duration) {
+	meterLock.RLock()
+	m := meter
+	meterLock.RUnlock()
+	if m == nil {
+		return
+	}
+	m.Record(
+		string(ctx.Request.Header.Peek(m.header)),
+		method,
+		ctx.Response.StatusCode(),
+		int64(len(ctx.Request.Body())),
+		int64(len(ctx.Response.Body())),
+		latency,
+	)
+}
+
+type usageResponse struct {
+	Since time.Time `json:"since"`
+	Usage []Row     `json:"usage"`
+}
+
+// handleUsage returns totals filtered by optional 'application' and 'method' query arguments,
+// applications are listed masked like in the quotas endpoint
+func handleUsage(ctx *fasthttp.RequestCtx) {
+	meterLock.RLock()
+	m := meter
+	meterLock.RUnlock()
+	if m == nil {
+		admin.SendJson(ctx, http.StatusNotFound, map[string]string{"error": "metering is disabled"})
+		return
+	}
+
+	application := string(ctx.QueryArgs().Peek("application"))
+	method := string(ctx.QueryArgs().Peek("method"))
+	rows, since := m.Totals()
+	filtered := rows[:0]
+	for _, r := range rows {
+		if (application == "" || r.Application == application) && (method == "" || r.Method == method) {
+			r.Application = admin.MaskKey(r.Application)
+			filtered = append(filtered, r)
+		}
+	}
+	admin.SendJson(ctx, http.StatusOK, usageResponse{Since: since, Usage: filtered})
+}
@@ -0,0 +1,122 @@
+package metering
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"isp-convert-service/conf"
+)
+
+func newTestMeter(t *testing.T, format string, now *time.Time) (*Meter, string) {
+	dir, err := ioutil.TempDir("", "metering")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMeter(conf.MeteringConfig{Directory: dir, Format: format})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.periodStart, m.since, m.totalsDay = *now, *now, fileDay(*now)
+	m.now = func() time.Time {
+		return *now
+	}
+	return m, dir
+}
+
+func readLines(t *testing.T, file string) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestMeter_RecordAndFlush(t *testing.T) {
+	now := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)
+	m, dir := newTestMeter(t, FormatCsv, &now)
+	defer os.RemoveAll(dir)
+
+	m.Record("app", "module/group/method", 200, 10, 100, 20*time.Millisecond)
+	m.Record("app", "module/group/method", 500, 5, 50, 30*time.Millisecond)
+	m.Record("other", "module/group/method", 200, 1, 1, time.Millisecond)
+	now = now.Add(time.Minute)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	m.Record("app", "module/group/method", 200, 1, 1, time.Millisecond)
+	now = now.Add(time.Minute)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, filepath.Join(dir, "usage-2020-01-31.csv"))
+	expected := []string{
+		"from,to,application,method,count,errors,bytesIn,bytesOut,latencyMs",
+		"2020-01-31T12:00:00Z,2020-01-31T12:01:00Z,app,module/group/method,2,1,15,150,50",
+		"2020-01-31T12:00:00Z,2020-01-31T12:01:00Z,other,module/group/method,1,0,1,1,1",
+		"2020-01-31T12:01:00Z,2020-01-31T12:02:00Z,app,module/group/method,1,0,1,1,1",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d: expected %q, got %q", i, expected[i], lines[i])
+		}
+	}
+
+	m.Record("app", "module/group/method", 200, 1, 1, time.Millisecond)
+	rows, _ := m.Totals()
+	if len(rows) != 2 || rows[0].Application != "app" || rows[0].Count != 4 || rows[1].Count != 1 {
+		t.Errorf("unexpected totals: %+v", rows)
+	}
+}
+
+func TestMeter_TotalsResetDaily(t *testing.T) {
+	now := time.Date(2020, 1, 31, 23, 59, 0, 0, time.UTC)
+	m, dir := newTestMeter(t, FormatJsonl, &now)
+	defer os.RemoveAll(dir)
+
+	m.Record("app", "old/group/method", 200, 0, 0, 0)
+	now = now.Add(30 * time.Second)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	m.Record("app", "module/group/method", 200, 0, 0, 0)
+	now = now.Add(time.Minute)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, since := m.Totals()
+	if len(rows) != 1 || rows[0].Method != "module/group/method" {
+		t.Errorf("expected totals of the previous day to be dropped, got %+v", rows)
+	}
+	if expected := time.Date(2020, 1, 31, 23, 59, 30, 0, time.UTC); !since.Equal(expected) {
+		t.Errorf("expected totals since %s, got %s", expected, since)
+	}
+	if lines := readLines(t, filepath.Join(dir, "usage-2020-02-01.jsonl")); len(lines) != 1 {
+		t.Errorf("expected one row in the file of the next day, got %q", lines)
+	}
+
+	now = now.Add(time.Minute)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := m.Totals(); len(rows) != 1 {
+		t.Errorf("totals must be kept within the day, got %+v", rows)
+	}
+}
@@ -0,0 +1,169 @@
+package mirror
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/integration-system/isp-lib/proto/stubs"
+	log "github.com/integration-system/isp-log"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/canary"
+	"isp-convert-service/conf"
+	"isp-convert-service/invoker"
+	"isp-convert-service/log_code"
+	"isp-convert-service/service"
+)
+
+const (
+	ResultSent     = "sent"
+	ResultDropped  = "dropped"
+	ResultError    = "error"
+	ResultMismatch = "mismatch"
+
+	// maxLoggedBody limits the size of bodies written to the log on mismatch
+	maxLoggedBody = 1024
+)
+
+var (
+	mirrors     []*Mirror
+	mirrorsLock sync.RWMutex
+)
+
+// Mirror sends copies of sampled calls to the shadow cluster, shadow responses never reach clients
+type Mirror struct {
+	name          string
+	methods       service.MethodMatcher
+	cluster       string
+	samplePercent float64
+	timeout       time.Duration
+	slots         chan struct{}
+	compare       bool
+}
+
+func ReceiveConfiguration(list []conf.MirrorConfig) error {
+	next := make([]*Mirror, 0, len(list))
+	for i, cfg := range list {
+		name := cfg.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if len(cfg.MethodsPatterns) == 0 {
+			return errors.Errorf("mirror %s: methods are not specified", name)
+		}
+		if err := invoker.CheckShadowCluster(cfg.Cluster); err != nil {
+			return errors.Wrapf(err, "mirror %s", name)
+		}
+		if split := canary.ClusterSplit(cfg.Cluster); split != "" {
+			return errors.Errorf("mirror %s: cluster '%s' serves client calls of split %s", name, cfg.Cluster, split)
+		}
+		next = append(next, &Mirror{
+			name:          name,
+			methods:       service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+			cluster:       cfg.Cluster,
+			samplePercent: cfg.GetSamplePercent(),
+			timeout:       cfg.GetTimeout(),
+			slots:         make(chan struct{}, cfg.GetMaxConcurrent()),
+			compare:       cfg.CompareResponses,
+		})
+	}
+
+	// shadow calls in flight hold slots of the mirrors they were sent by
+	mirrorsLock.Lock()
+	mirrors = next
+	mirrorsLock.Unlock()
+	return nil
+}
+
+// Send copies the call to the shadow clusters of all mirrors matching the method.
+// It never blocks, copies over the concurrency limit of a mirror are dropped
+func Send(method string, md metadata.MD, request, response *isp.Message, err error) {
+	mirrorsLock.RLock()
+	list := mirrors
+	mirrorsLock.RUnlock()
+
+	var shadowRequest *isp.Message
+	for _, m := range list {
+		if !m.methods.Match(method) || rand.Float64()*100 >= m.samplePercent {
+			continue
+		}
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			service.GetMetrics().UpdateMirror(m.name, ResultDropped)
+			continue
+		}
+		if shadowRequest == nil {
+			shadowRequest = copyRequest(request)
+		}
+		go func(m *Mirror, md metadata.MD) {
+			defer func() { <-m.slots }()
+			m.send(method, md, shadowRequest, response, err)
+		}(m, md.Copy())
+	}
+}
+
+// copyRequest detaches the body from the buffer of the http request, which is reused once the handler returns
+func copyRequest(request *isp.Message) *isp.Message {
+	if body, ok := request.GetBody().(*isp.Message_BytesBody); ok {
+		return &isp.Message{Body: &isp.Message_BytesBody{BytesBody: append([]byte(nil), body.BytesBody...)}}
+	}
+	return request
+}
+
+func (m *Mirror) send(method string, md metadata.MD, request, response *isp.Message, err error) {
+	client, connErr := invoker.ShadowConn(m.cluster)
+	if connErr != nil {
+		service.GetMetrics().UpdateMirror(m.name, ResultError)
+		return
+	}
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), m.timeout)
+	defer cancel()
+	shadowResponse, shadowErr := client.Request(ctx, request)
+
+	metrics := service.GetMetrics()
+	metrics.UpdateMirror(m.name, ResultSent)
+	if shadowErr != nil && err == nil {
+		metrics.UpdateMirror(m.name, ResultError)
+	}
+	if !m.compare || Equal(response, err, shadowResponse, shadowErr) {
+		return
+	}
+	metrics.UpdateMirror(m.name, ResultMismatch)
+	log.Warnf(log_code.WarnMirrorMismatch,
+		"mirror %s: response of method %s differs in shadow cluster %s. primary: %s; shadow: %s",
+		m.name, method, m.cluster, describe(response, err), describe(shadowResponse, shadowErr),
+	)
+}
+
+// Equal compares results of the primary and the shadow calls, errors are compared by code and message
+func Equal(response *isp.Message, err error, shadowResponse *isp.Message, shadowErr error) bool {
+	if err != nil || shadowErr != nil {
+		if err == nil || shadowErr == nil {
+			return false
+		}
+		s, _ := status.FromError(err)
+		shadow, _ := status.FromError(shadowErr)
+		return s.Code() == shadow.Code() && s.Message() == shadow.Message()
+	}
+	return proto.Equal(response, shadowResponse)
+}
+
+func describe(response *isp.Message, err error) string {
+	var text string
+	if err != nil {
+		s, _ := status.FromError(err)
+		text = s.Code().String() + ": " + s.Message()
+	} else {
+		text = proto.CompactTextString(response)
+	}
+	if len(text) > maxLoggedBody {
+		return text[:maxLoggedBody] + "..."
+	}
+	return text
+}
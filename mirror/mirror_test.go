@@ -0,0 +1,42 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/integration-system/isp-lib/proto/stubs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+)
+
+func TestEqual_Errors(t *testing.T) {
+	notFound := status.Error(codes.NotFound, "entity not found")
+	if !Equal(nil, notFound, nil, status.Error(codes.NotFound, "entity not found")) {
+		t.Fatal("errors with the same code and message are expected to be equal")
+	}
+	if Equal(nil, notFound, nil, status.Error(codes.Internal, "entity not found")) {
+		t.Fatal("errors with different codes are expected to differ")
+	}
+	if Equal(nil, notFound, nil, nil) || Equal(nil, nil, nil, notFound) {
+		t.Fatal("error and response are expected to differ")
+	}
+}
+
+func TestCopyRequest(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	request := &isp.Message{Body: &isp.Message_BytesBody{BytesBody: body}}
+	shadow := copyRequest(request)
+	copy(body, `{"id":2}`)
+	if data := string(shadow.GetBytesBody()); data != `{"id":1}` {
+		t.Errorf("shadow request must not share the body buffer, got %s", data)
+	}
+}
+
+func TestReceiveConfiguration_ProductionCluster(t *testing.T) {
+	for _, cluster := range []string{"router", "unknown"} {
+		err := ReceiveConfiguration([]conf.MirrorConfig{{MethodsPatterns: []string{"*/*/*"}, Cluster: cluster}})
+		if err == nil {
+			t.Errorf("mirror to cluster '%s' must be rejected", cluster)
+		}
+	}
+}
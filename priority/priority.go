@@ -0,0 +1,195 @@
+package priority
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/realip"
+	"isp-convert-service/service"
+)
+
+const (
+	// queueDelayWeight is the weight of a new sample in the moving average of the queue delay
+	queueDelayWeight = 0.1
+	// queueDelayHalfLife halves the queue delay without new samples, so shedding every tier does not freeze the load
+	queueDelayHalfLife = time.Second
+)
+
+var (
+	shedder     *Shedder
+	shedderLock sync.RWMutex
+)
+
+type Tier struct {
+	name         string
+	methods      service.MethodMatcher
+	headerValues map[string]bool
+	shedAt       float64
+}
+
+// Shedder rejects tiers whose load threshold is reached, so lower tiers with lower thresholds go first
+type Shedder struct {
+	header        string
+	tiers         []*Tier
+	defaultTier   *Tier
+	maxInflight   float64
+	maxQueueDelay time.Duration
+
+	inflight   int32
+	delayLock  sync.Mutex
+	queueDelay float64
+	sampledAt  time.Time
+	now        func() time.Time
+}
+
+func NewShedder(cfg conf.PriorityConfig) (*Shedder, error) {
+	if cfg.MaxInflight <= 0 && cfg.MaxQueueDelayMs <= 0 {
+		return nil, errors.New("priority: neither max in-flight calls nor max queue delay is specified")
+	}
+	s := &Shedder{
+		header:        cfg.Header,
+		tiers:         make([]*Tier, 0, len(cfg.Tiers)),
+		maxInflight:   float64(cfg.MaxInflight),
+		maxQueueDelay: time.Duration(cfg.MaxQueueDelayMs) * time.Millisecond,
+		now:           time.Now,
+	}
+	for _, t := range cfg.Tiers {
+		if t.Name == "" {
+			return nil, errors.New("priority: tier name is empty")
+		}
+		tier := &Tier{
+			name:         t.Name,
+			methods:      service.NewCacheableMethodMatcher(t.MethodsPatterns),
+			headerValues: make(map[string]bool, len(t.HeaderValues)),
+			shedAt:       float64(t.GetShedAtPercent()) / 100,
+		}
+		for _, v := range t.HeaderValues {
+			tier.headerValues[v] = true
+		}
+		s.tiers = append(s.tiers, tier)
+		if t.Name == cfg.DefaultTier {
+			s.defaultTier = tier
+		}
+	}
+	if cfg.DefaultTier != "" && s.defaultTier == nil {
+		return nil, errors.Errorf("priority: unknown default tier '%s'", cfg.DefaultTier)
+	}
+	return s, nil
+}
+
+// Classify prefers the header, which is honored only from trusted proxies, then method patterns in tier order
+func (s *Shedder) Classify(ctx *fasthttp.RequestCtx, method string) *Tier {
+	if s.header != "" && realip.IsTrustedProxy(ctx.RemoteIP()) {
+		if value := string(ctx.Request.Header.Peek(s.header)); value != "" {
+			for _, t := range s.tiers {
+				if t.headerValues[value] {
+					return t
+				}
+			}
+		}
+	}
+	for _, t := range s.tiers {
+		if t.methods.Match(method) {
+			return t
+		}
+	}
+	return s.defaultTier
+}
+
+// Load is the greatest of in-flight calls and queue delay relative to their maximums
+func (s *Shedder) Load() float64 {
+	load := 0.0
+	if s.maxInflight > 0 {
+		load = float64(atomic.LoadInt32(&s.inflight)) / s.maxInflight
+	}
+	if s.maxQueueDelay > 0 {
+		s.delayLock.Lock()
+		delay := s.decayedQueueDelay(s.now())
+		s.delayLock.Unlock()
+		if d := delay / float64(s.maxQueueDelay); d > load {
+			load = d
+		}
+	}
+	return load
+}
+
+// Admit counts the call in flight, unclassified calls are never shed but still count to the load
+func (s *Shedder) Admit(t *Tier) (func(), bool) {
+	if t != nil && s.Load() >= t.shedAt {
+		return nil, false
+	}
+	atomic.AddInt32(&s.inflight, 1)
+	return s.release, true
+}
+
+func (s *Shedder) release() {
+	atomic.AddInt32(&s.inflight, -1)
+}
+
+func (s *Shedder) ObserveQueueDelay(delay time.Duration) {
+	s.delayLock.Lock()
+	now := s.now()
+	s.queueDelay = s.decayedQueueDelay(now)
+	s.queueDelay += (float64(delay) - s.queueDelay) * queueDelayWeight
+	s.sampledAt = now
+	s.delayLock.Unlock()
+}
+
+// decayedQueueDelay fades the average with the time since the last sample, shed calls bring no samples
+func (s *Shedder) decayedQueueDelay(now time.Time) float64 {
+	elapsed := now.Sub(s.sampledAt)
+	if s.sampledAt.IsZero() || elapsed <= 0 {
+		return s.queueDelay
+	}
+	return s.queueDelay * math.Pow(0.5, float64(elapsed)/float64(queueDelayHalfLife))
+}
+
+func ReceiveConfiguration(cfg conf.PriorityConfig) error {
+	var next *Shedder
+	if cfg.Enable {
+		var err error
+		if next, err = NewShedder(cfg); err != nil {
+			return err
+		}
+	}
+	shedderLock.Lock()
+	shedder = next
+	shedderLock.Unlock()
+	return nil
+}
+
+func current() *Shedder {
+	shedderLock.RLock()
+	s := shedder
+	shedderLock.RUnlock()
+	return s
+}
+
+// Admit sheds the call with Unavailable if the load reached the threshold of its tier
+func Admit(ctx *fasthttp.RequestCtx, method string) (func(), error) {
+	s := current()
+	if s == nil {
+		return func() {}, nil
+	}
+	t := s.Classify(ctx, method)
+	release, ok := s.Admit(t)
+	if !ok {
+		service.GetMetrics().UpdatePriorityShed(t.name)
+		return nil, status.Errorf(codes.Unavailable, "service is overloaded, requests of tier '%s' are shed", t.name)
+	}
+	return release, nil
+}
+
+// ObserveQueueDelay records how long an admitted call waited for concurrency limits
+func ObserveQueueDelay(delay time.Duration) {
+	if s := current(); s != nil {
+		s.ObserveQueueDelay(delay)
+	}
+}
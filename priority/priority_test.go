@@ -0,0 +1,102 @@
+package priority
+
+import (
+	"testing"
+	"time"
+
+	"isp-convert-service/conf"
+)
+
+func TestShedder_Admit(t *testing.T) {
+	s, err := NewShedder(conf.PriorityConfig{
+		MaxInflight:     4,
+		MaxQueueDelayMs: 100,
+		Tiers: []conf.PriorityTierConfig{
+			{Name: "interactive"},
+			{Name: "batch", MethodsPatterns: []string{"reports/*"}, ShedAtPercent: 50},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interactive, batch := s.tiers[0], s.tiers[1]
+
+	releases := make([]func(), 0)
+	for i := 0; i < 2; i++ {
+		release, ok := s.Admit(batch)
+		if !ok {
+			t.Fatalf("batch call %d must be admitted below threshold", i)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := s.Admit(batch); ok {
+		t.Fatal("batch must be shed at half of max in-flight calls")
+	}
+	for i := 0; i < 2; i++ {
+		release, ok := s.Admit(interactive)
+		if !ok {
+			t.Fatalf("interactive call %d must be admitted", i)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := s.Admit(interactive); ok {
+		t.Fatal("interactive must be shed at max in-flight calls")
+	}
+	if _, ok := s.Admit(nil); !ok {
+		t.Fatal("unclassified calls must not be shed")
+	}
+	for _, release := range releases {
+		release()
+	}
+
+	for i := 0; i < 50; i++ {
+		s.ObserveQueueDelay(80 * time.Millisecond)
+	}
+	if _, ok := s.Admit(batch); ok {
+		t.Fatal("batch must be shed by queue delay")
+	}
+	if _, ok := s.Admit(interactive); !ok {
+		t.Fatal("interactive must be admitted below max queue delay")
+	}
+}
+
+func TestShedder_QueueDelayDecays(t *testing.T) {
+	s, err := NewShedder(conf.PriorityConfig{
+		MaxQueueDelayMs: 100,
+		DefaultTier:     "interactive",
+		Tiers: []conf.PriorityTierConfig{
+			{Name: "interactive"},
+			{Name: "batch", MethodsPatterns: []string{"reports/*"}, ShedAtPercent: 50},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	interactive, batch := s.tiers[0], s.tiers[1]
+
+	for i := 0; i < 50; i++ {
+		now = now.Add(time.Millisecond)
+		s.ObserveQueueDelay(500 * time.Millisecond)
+	}
+	for _, tier := range []*Tier{interactive, batch} {
+		if _, ok := s.Admit(tier); ok {
+			t.Fatalf("tier '%s' must be shed above every threshold", tier.name)
+		}
+	}
+
+	now = now.Add(3 * time.Second)
+	release, ok := s.Admit(interactive)
+	if !ok {
+		t.Fatal("interactive must be admitted once the queue delay decayed")
+	}
+	release()
+	if _, ok := s.Admit(batch); ok {
+		t.Fatal("batch must still be shed above half of max queue delay")
+	}
+	now = now.Add(time.Second)
+	if _, ok := s.Admit(batch); !ok {
+		t.Fatal("batch must be admitted once the queue delay decayed below its threshold")
+	}
+}
@@ -0,0 +1,312 @@
+package quota
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/integration-system/isp-log"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/admin"
+	"isp-convert-service/conf"
+	"isp-convert-service/log_code"
+	"isp-convert-service/service"
+)
+
+const (
+	headerDailyLimit       = "X-Quota-Daily-Limit"
+	headerDailyRemaining   = "X-Quota-Daily-Remaining"
+	headerMonthlyLimit     = "X-Quota-Monthly-Limit"
+	headerMonthlyRemaining = "X-Quota-Monthly-Remaining"
+	headerRetryAfter       = "Retry-After"
+
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+
+	userValueKey = "quota.charge"
+)
+
+var (
+	enforcer     *quotaEnforcer
+	enforcerLock sync.RWMutex
+
+	store     *Store
+	stopFlush chan struct{}
+	storeLock sync.Mutex
+)
+
+func init() {
+	admin.Handle("GET", "/quotas", handleQuotas)
+}
+
+// charge remembers the counted call, so it can be refunded if the router is not called
+type charge struct {
+	store *Store
+	app   string
+	day   string
+	month string
+}
+
+type plan struct {
+	name    string
+	daily   int64
+	monthly int64
+}
+
+type quotaEnforcer struct {
+	header      string
+	methods     service.MethodMatcher
+	all         bool
+	plans       map[string]*plan
+	defaultPlan *plan
+	location    *time.Location
+	store       *Store
+}
+
+func (e *quotaEnforcer) plan(app string) *plan {
+	if p, ok := e.plans[app]; ok {
+		return p
+	}
+	return e.defaultPlan
+}
+
+func (e *quotaEnforcer) windows(now time.Time) (string, string) {
+	now = now.In(e.location)
+	return now.Format(dayLayout), now.Format(monthLayout)
+}
+
+func newEnforcer(cfg conf.QuotasConfig, store *Store) (*quotaEnforcer, error) {
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "quota timezone")
+	}
+	e := &quotaEnforcer{
+		header:   cfg.GetHeader(),
+		methods:  service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+		all:      len(cfg.MethodsPatterns) == 0,
+		plans:    make(map[string]*plan),
+		location: location,
+		store:    store,
+	}
+	byName := make(map[string]*plan, len(cfg.Plans))
+	for _, p := range cfg.Plans {
+		if p.Name == "" {
+			return nil, errors.New("quota plan name is empty")
+		}
+		next := &plan{name: p.Name, daily: p.DailyLimit, monthly: p.MonthlyLimit}
+		byName[p.Name] = next
+		for _, app := range p.Applications {
+			if prev, ok := e.plans[app]; ok {
+				return nil, errors.Errorf("application is assigned to plans '%s' and '%s'", prev.name, p.Name)
+			}
+			e.plans[app] = next
+		}
+	}
+	if cfg.DefaultPlan != "" {
+		p, ok := byName[cfg.DefaultPlan]
+		if !ok {
+			return nil, errors.Errorf("unknown default quota plan '%s'", cfg.DefaultPlan)
+		}
+		e.defaultPlan = p
+	}
+	return e, nil
+}
+
+// ReceiveConfiguration reopens the store only if the file is changed, so counters are not lost on config updates
+func ReceiveConfiguration(cfg conf.QuotasConfig) error {
+	if !cfg.Enable {
+		enforcerLock.Lock()
+		enforcer = nil
+		enforcerLock.Unlock()
+		Close()
+		return nil
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	current := store
+	if current == nil || current.file != cfg.GetStoreFile() {
+		var err error
+		if current, err = OpenStore(cfg.GetStoreFile()); err != nil {
+			return err
+		}
+	}
+	next, err := newEnforcer(cfg, current)
+	if err != nil {
+		return err
+	}
+
+	closeStore()
+	store = current
+	stopFlush = make(chan struct{})
+	go flushPeriodically(store, cfg.GetFlushPeriod(), stopFlush)
+
+	enforcerLock.Lock()
+	enforcer = next
+	enforcerLock.Unlock()
+	return nil
+}
+
+// Close stops periodic flushing and writes the last snapshot
+func Close() {
+	storeLock.Lock()
+	closeStore()
+	store = nil
+	storeLock.Unlock()
+}
+
+func closeStore() {
+	if stopFlush != nil {
+		close(stopFlush)
+		stopFlush = nil
+	}
+	if store != nil {
+		if err := store.Flush(); err != nil {
+			log.Errorf(log_code.ErrorQuotaStore, "could not flush quota store: %v", err)
+		}
+	}
+}
+
+func flushPeriodically(s *Store, period time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Errorf(log_code.ErrorQuotaStore, "could not flush quota store: %v", err)
+			}
+		}
+	}
+}
+
+// Check counts the call against the plan of the application and reports remaining quota in headers.
+// It is called after the request is admitted by concurrency limits, so rejected requests are not charged
+func Check(ctx *fasthttp.RequestCtx, method string) error {
+	enforcerLock.RLock()
+	e := enforcer
+	enforcerLock.RUnlock()
+	if e == nil || (!e.all && !e.methods.Match(method)) {
+		return nil
+	}
+	app := string(ctx.Request.Header.Peek(e.header))
+	if app == "" {
+		return nil
+	}
+	p := e.plan(app)
+	if p == nil {
+		return nil
+	}
+
+	now := time.Now()
+	day, month := e.windows(now)
+	usage, ok := e.store.Take(app, day, month, p.daily, p.monthly)
+
+	header := &ctx.Response.Header
+	if p.daily > 0 {
+		header.Set(headerDailyLimit, strconv.FormatInt(p.daily, 10))
+		header.Set(headerDailyRemaining, strconv.FormatInt(remaining(p.daily, usage.DayCount), 10))
+	}
+	if p.monthly > 0 {
+		header.Set(headerMonthlyLimit, strconv.FormatInt(p.monthly, 10))
+		header.Set(headerMonthlyRemaining, strconv.FormatInt(remaining(p.monthly, usage.MonthCount), 10))
+	}
+	if ok {
+		ctx.SetUserValue(userValueKey, charge{store: e.store, app: app, day: day, month: month})
+		return nil
+	}
+
+	local := now.In(e.location)
+	window, resetAt := "daily", time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, e.location)
+	if p.monthly > 0 && usage.MonthCount >= p.monthly {
+		window, resetAt = "monthly", time.Date(local.Year(), local.Month()+1, 1, 0, 0, 0, 0, e.location)
+	}
+	header.Set(headerRetryAfter, strconv.FormatInt(int64(resetAt.Sub(now)/time.Second)+1, 10))
+	return status.Errorf(codes.ResourceExhausted, "%s quota of plan '%s' is exceeded", window, p.name)
+}
+
+// Refund returns the call counted by Check, used when the request is rejected before reaching the router
+func Refund(ctx *fasthttp.RequestCtx) {
+	c, ok := ctx.UserValue(userValueKey).(charge)
+	if !ok {
+		return
+	}
+	ctx.SetUserValue(userValueKey, nil)
+	c.store.Refund(c.app, c.day, c.month)
+}
+
+func remaining(limit, used int64) int64 {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+type windowUsage struct {
+	Window    string `json:"window"`
+	Used      int64  `json:"used"`
+	Limit     int64  `json:"limit,omitempty"`
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+type applicationUsage struct {
+	Application string      `json:"application"`
+	Plan        string      `json:"plan,omitempty"`
+	Daily       windowUsage `json:"daily"`
+	Monthly     windowUsage `json:"monthly"`
+}
+
+// handleQuotas lists usage of all applications, or of one application given in the 'application' query argument.
+// Applications are identified by tokens, so they are listed masked
+func handleQuotas(ctx *fasthttp.RequestCtx) {
+	enforcerLock.RLock()
+	e := enforcer
+	enforcerLock.RUnlock()
+	if e == nil {
+		admin.SendJson(ctx, http.StatusNotFound, map[string]string{"error": "quotas are disabled"})
+		return
+	}
+
+	day, month := e.windows(time.Now())
+	snapshot := e.store.Snapshot(day, month)
+	filter := string(ctx.QueryArgs().Peek("application"))
+
+	result := make([]applicationUsage, 0, len(snapshot))
+	for app, u := range snapshot {
+		if filter != "" && app != filter {
+			continue
+		}
+		item := applicationUsage{
+			Application: admin.MaskKey(app),
+			Daily:       windowUsage{Window: u.Day, Used: u.DayCount},
+			Monthly:     windowUsage{Window: u.Month, Used: u.MonthCount},
+		}
+		if p := e.plan(app); p != nil {
+			item.Plan = p.name
+			item.Daily.setLimit(p.daily)
+			item.Monthly.setLimit(p.monthly)
+		}
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Application < result[j].Application
+	})
+	admin.SendJson(ctx, http.StatusOK, result)
+}
+
+func (w *windowUsage) setLimit(limit int64) {
+	if limit <= 0 {
+		return
+	}
+	left := remaining(limit, w.Used)
+	w.Limit = limit
+	w.Remaining = &left
+}
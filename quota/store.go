@@ -0,0 +1,165 @@
+package quota
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Usage holds the counters of the current day and month of an application
+type Usage struct {
+	Day        string `json:"day"`
+	DayCount   int64  `json:"dayCount"`
+	Month      string `json:"month"`
+	MonthCount int64  `json:"monthCount"`
+}
+
+func (u *Usage) roll(day, month string) {
+	if u.Day != day {
+		u.Day = day
+		u.DayCount = 0
+	}
+	if u.Month != month {
+		u.Month = month
+		u.MonthCount = 0
+	}
+}
+
+// Store keeps usage in memory and persists a snapshot to a json file, so a crash loses at most one flush period
+type Store struct {
+	file  string
+	lock  sync.Mutex
+	usage map[string]*Usage
+	month string
+	dirty bool
+	// flushLock serialises writers of the temporary file
+	flushLock sync.Mutex
+}
+
+func OpenStore(file string) (*Store, error) {
+	s := &Store{file: file, usage: make(map[string]*Usage)}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read quota store %s", file)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.usage); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal quota store %s", file)
+		}
+	}
+	return s, nil
+}
+
+// Take counts a call if it fits both limits, zero limit means the window is unlimited
+func (s *Store) Take(app, day, month string, dailyLimit, monthlyLimit int64) (Usage, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.expire(month)
+	u, ok := s.usage[app]
+	if !ok {
+		u = &Usage{}
+		s.usage[app] = u
+	}
+	u.roll(day, month)
+	if (dailyLimit > 0 && u.DayCount >= dailyLimit) || (monthlyLimit > 0 && u.MonthCount >= monthlyLimit) {
+		return *u, false
+	}
+	u.DayCount++
+	u.MonthCount++
+	s.dirty = true
+	return *u, true
+}
+
+// Refund returns a call counted by Take, if its windows are still current
+func (s *Store) Refund(app, day, month string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	u, ok := s.usage[app]
+	if !ok {
+		return
+	}
+	if u.Day == day && u.DayCount > 0 {
+		u.DayCount--
+	}
+	if u.Month == month && u.MonthCount > 0 {
+		u.MonthCount--
+	}
+	s.dirty = true
+}
+
+// expire drops applications without calls in the given month once the month changes
+func (s *Store) expire(month string) {
+	if s.month == month {
+		return
+	}
+	for app, u := range s.usage {
+		if u.Month != month {
+			delete(s.usage, app)
+			s.dirty = true
+		}
+	}
+	s.month = month
+}
+
+// Snapshot returns usage of all applications in the given windows
+func (s *Store) Snapshot(day, month string) map[string]Usage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.expire(month)
+	result := make(map[string]Usage, len(s.usage))
+	for app, u := range s.usage {
+		current := *u
+		current.roll(day, month)
+		result[app] = current
+	}
+	return result
+}
+
+// Flush writes the snapshot to a temporary file and renames it over the store file
+func (s *Store) Flush() error {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	s.lock.Lock()
+	if !s.dirty {
+		s.lock.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.usage)
+	s.dirty = false
+	s.lock.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "marshal quota store")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		s.markDirty()
+		return errors.Wrapf(err, "create quota store dir")
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		s.markDirty()
+		return errors.Wrapf(err, "write quota store %s", tmp)
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		s.markDirty()
+		return errors.Wrapf(err, "replace quota store %s", s.file)
+	}
+	return nil
+}
+
+func (s *Store) markDirty() {
+	s.lock.Lock()
+	s.dirty = true
+	s.lock.Unlock()
+}
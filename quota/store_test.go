@@ -0,0 +1,107 @@
+package quota
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStore_TakeAndFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "nested", "quotas.json")
+
+	s, err := OpenStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := s.Take("app", "2020-01-31", "2020-01", 2, 3); !ok {
+			t.Fatalf("call %d must fit the daily limit", i)
+		}
+	}
+	if u, ok := s.Take("app", "2020-01-31", "2020-01", 2, 3); ok || u.DayCount != 2 {
+		t.Fatalf("expected daily limit to be exceeded, got %+v", u)
+	}
+	if _, ok := s.Take("app", "2020-02-01", "2020-01", 2, 3); !ok {
+		t.Fatal("next day must reset the daily counter")
+	}
+	if _, ok := s.Take("app", "2020-02-01", "2020-01", 2, 3); ok {
+		t.Fatal("expected monthly limit to be exceeded")
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := reopened.Snapshot("2020-02-01", "2020-01")["app"]
+	if u.DayCount != 1 || u.MonthCount != 3 {
+		t.Errorf("unexpected usage after reopen: %+v", u)
+	}
+	if u := reopened.Snapshot("2020-02-02", "2020-02")["app"]; u.DayCount != 0 || u.MonthCount != 0 {
+		t.Errorf("snapshot must roll windows, got %+v", u)
+	}
+}
+
+func TestStore_ExpireAndRefund(t *testing.T) {
+	s := &Store{usage: make(map[string]*Usage)}
+	s.Take("old", "2020-01-31", "2020-01", 0, 0)
+	s.Take("app", "2020-01-31", "2020-01", 0, 0)
+	s.Take("app", "2020-02-01", "2020-02", 0, 0)
+	if _, ok := s.usage["old"]; ok {
+		t.Error("usage of the finished month is expected to be dropped")
+	}
+
+	s.Take("app", "2020-02-01", "2020-02", 0, 0)
+	s.Refund("app", "2020-02-01", "2020-02")
+	if u := s.Snapshot("2020-02-01", "2020-02")["app"]; u.DayCount != 1 || u.MonthCount != 1 {
+		t.Errorf("expected refunded call to be subtracted, got %+v", u)
+	}
+	s.Refund("app", "2020-01-31", "2020-01")
+	if u := s.Snapshot("2020-02-01", "2020-02")["app"]; u.DayCount != 1 || u.MonthCount != 1 {
+		t.Errorf("refund of finished windows must be ignored, got %+v", u)
+	}
+}
+
+func TestStore_ConcurrentFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := OpenStore(filepath.Join(dir, "quotas.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				s.Take("app", "2020-01-31", "2020-01", 0, 0)
+				if err := s.Flush(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := OpenStore(s.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := reopened.Snapshot("2020-01-31", "2020-01")["app"]; u.MonthCount != 160 {
+		t.Errorf("expected all calls to be flushed, got %+v", u)
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// tokenBucket starts full, refills continuously with rate tokens per second and holds at most burst tokens
+type tokenBucket struct {
+	tokens   float64
+	last     time.Time
+	rate     float64
+	burst    float64
+	lastUsed time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		tokens:   float64(burst),
+		last:     now,
+		rate:     rate,
+		burst:    float64(burst),
+		lastUsed: now,
+	}
+}
+
+type decision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// check reports the decision of take without taking a token
+func (b *tokenBucket) check(now time.Time) decision {
+	b.refill(now)
+	d := decision{limit: int(b.burst), allowed: b.tokens >= 1}
+	tokens := b.tokens
+	if d.allowed {
+		tokens--
+	} else {
+		d.retryAfter = b.durationFor(1 - tokens)
+	}
+	d.remaining = int(math.Floor(tokens))
+	d.reset = b.durationFor(b.burst - tokens)
+	return d
+}
+
+func (b *tokenBucket) take(now time.Time) decision {
+	d := b.check(now)
+	b.lastUsed = now
+	if d.allowed {
+		b.tokens--
+	}
+	return d
+}
+
+// giveBack returns a token taken for a call that was rejected by another rule
+func (b *tokenBucket) giveBack() {
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// idle reports whether the bucket has refilled completely, so it can be dropped without changing behaviour
+func (b *tokenBucket) idle(now time.Time) bool {
+	return now.Sub(b.lastUsed) >= b.durationFor(b.burst-b.tokens)
+}
+
+func (b *tokenBucket) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / b.rate * float64(time.Second))
+}
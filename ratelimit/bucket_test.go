@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newTokenBucket(2, 3, now)
+
+	for i := 0; i < 3; i++ {
+		if d := b.take(now); !d.allowed || d.remaining != 2-i {
+			t.Fatalf("request %d: expected allowed with %d remaining, got %+v", i, 2-i, d)
+		}
+	}
+	d := b.take(now)
+	if d.allowed {
+		t.Fatal("expected burst to be exhausted")
+	}
+	if d.retryAfter != 500*time.Millisecond {
+		t.Errorf("expected retry after 500ms, got %v", d.retryAfter)
+	}
+	if d.reset != 1500*time.Millisecond {
+		t.Errorf("expected reset after 1.5s, got %v", d.reset)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if d := b.take(now); !d.allowed {
+		t.Fatalf("expected refilled token, got %+v", d)
+	}
+	if b.idle(now) {
+		t.Error("bucket must not be idle while refilling")
+	}
+	if !b.idle(now.Add(2 * time.Second)) {
+		t.Error("expected refilled bucket to be idle")
+	}
+}
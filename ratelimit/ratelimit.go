@@ -0,0 +1,241 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+	"isp-convert-service/realip"
+	"isp-convert-service/service"
+)
+
+const (
+	headerRetryAfter         = "Retry-After"
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+
+	cleanupInterval = time.Minute
+)
+
+var (
+	limiter     *Limiter
+	limiterLock sync.RWMutex
+)
+
+type rule struct {
+	name       string
+	methods    service.MethodMatcher
+	all        bool
+	header     string
+	byClientIp bool
+	perMethod  bool
+	rate       float64
+	burst      int
+
+	buckets *bucketSet
+}
+
+// bucketSet holds the buckets of a rule, rules keeping name, rate and burst on reload share it
+type bucketSet struct {
+	lock        sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+// bucket must be called under the lock
+func (r *rule) bucket(key string, now time.Time) *tokenBucket {
+	s := r.buckets
+	if now.Sub(s.lastCleanup) > cleanupInterval {
+		for k, b := range s.buckets {
+			if b.idle(now) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastCleanup = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = newTokenBucket(r.rate, r.burst, now)
+		s.buckets[key] = b
+	}
+	return b
+}
+
+func (r *rule) check(key string, now time.Time) decision {
+	r.buckets.lock.Lock()
+	defer r.buckets.lock.Unlock()
+	return r.bucket(key, now).check(now)
+}
+
+func (r *rule) take(key string, now time.Time) decision {
+	r.buckets.lock.Lock()
+	defer r.buckets.lock.Unlock()
+	return r.bucket(key, now).take(now)
+}
+
+func (r *rule) giveBack(key string, now time.Time) {
+	r.buckets.lock.Lock()
+	defer r.buckets.lock.Unlock()
+	r.bucket(key, now).giveBack()
+}
+
+type Limiter struct {
+	rules []*rule
+}
+
+func NewLimiter(list []conf.RateLimitConfig) (*Limiter, error) {
+	l := &Limiter{rules: make([]*rule, 0, len(list))}
+	now := time.Now()
+	for i, cfg := range list {
+		if cfg.RequestsPerSecond <= 0 {
+			return nil, errors.Errorf("rate limit %d: requests per second must be positive", i)
+		}
+		name := cfg.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		burst := cfg.Burst
+		if burst <= 0 {
+			burst = int(math.Max(1, math.Ceil(cfg.RequestsPerSecond)))
+		}
+		l.rules = append(l.rules, &rule{
+			name:       name,
+			methods:    service.NewCacheableMethodMatcher(cfg.MethodsPatterns),
+			all:        len(cfg.MethodsPatterns) == 0,
+			header:     cfg.KeyHeader,
+			byClientIp: cfg.ByClientIp,
+			perMethod:  cfg.PerMethod,
+			rate:       cfg.RequestsPerSecond,
+			burst:      burst,
+			buckets:    &bucketSet{buckets: make(map[string]*tokenBucket), lastCleanup: now},
+		})
+	}
+	return l, nil
+}
+
+func ReceiveConfiguration(list []conf.RateLimitConfig) error {
+	next, err := NewLimiter(list)
+	if err != nil {
+		return err
+	}
+	limiterLock.Lock()
+	next.inherit(limiter)
+	limiter = next
+	limiterLock.Unlock()
+	return nil
+}
+
+// inherit carries over buckets of rules with unchanged name, rate and burst, so reloads do not refill them
+func (l *Limiter) inherit(prev *Limiter) {
+	if prev == nil {
+		return
+	}
+	for _, r := range l.rules {
+		for _, p := range prev.rules {
+			if p.name == r.name && p.rate == r.rate && p.burst == r.burst {
+				r.buckets = p.buckets
+				break
+			}
+		}
+	}
+}
+
+// Check takes a token from the bucket of every matching rule and reports the most restrictive one in headers.
+// Tokens are taken only if all matching rules allow the call
+func Check(ctx *fasthttp.RequestCtx, method string) error {
+	limiterLock.RLock()
+	l := limiter
+	limiterLock.RUnlock()
+	if l == nil || len(l.rules) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var (
+		strictest *decision
+		rejected  *rule
+		matched   = make([]match, 0, len(l.rules))
+	)
+	for _, r := range l.rules {
+		if !r.all && !r.methods.Match(method) {
+			continue
+		}
+		key, ok := r.key(ctx, method)
+		if !ok {
+			continue
+		}
+		if d := r.check(key, now); !d.allowed {
+			rejected, strictest = r, &d
+			break
+		}
+		matched = append(matched, match{rule: r, key: key})
+	}
+	if rejected == nil {
+		for i, m := range matched {
+			d := m.rule.take(m.key, now)
+			if !d.allowed {
+				// a concurrent call took the last token after the check
+				for _, taken := range matched[:i] {
+					taken.rule.giveBack(taken.key, now)
+				}
+				rejected, strictest = m.rule, &d
+				break
+			}
+			if strictest == nil || d.remaining < strictest.remaining {
+				strictest = &d
+			}
+		}
+	}
+	if strictest == nil {
+		return nil
+	}
+
+	header := &ctx.Response.Header
+	header.Set(headerRateLimitLimit, strconv.Itoa(strictest.limit))
+	header.Set(headerRateLimitRemaining, strconv.Itoa(strictest.remaining))
+	header.Set(headerRateLimitReset, strconv.Itoa(ceilSeconds(strictest.reset)))
+	if rejected == nil {
+		return nil
+	}
+
+	header.Set(headerRetryAfter, strconv.Itoa(ceilSeconds(strictest.retryAfter)))
+	service.GetMetrics().UpdateRateLimitRejected(rejected.name)
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %d seconds", ceilSeconds(strictest.retryAfter))
+}
+
+type match struct {
+	rule *rule
+	key  string
+}
+
+// key builds the bucket key, requests without the configured header are not limited by the rule
+func (r *rule) key(ctx *fasthttp.RequestCtx, method string) (string, bool) {
+	parts := make([]string, 0, 3)
+	if r.perMethod {
+		parts = append(parts, method)
+	}
+	if r.header != "" {
+		value := string(ctx.Request.Header.Peek(r.header))
+		if value == "" {
+			return "", false
+		}
+		parts = append(parts, value)
+	}
+	if r.byClientIp {
+		parts = append(parts, realip.FromRequest(ctx).IP.String())
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+)
+
+func requestCtx(client string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("X-Client", client)
+	return ctx
+}
+
+func TestCheck_RejectedCallTakesNoTokens(t *testing.T) {
+	service.InitMetrics()
+	err := ReceiveConfiguration([]conf.RateLimitConfig{
+		{Name: "client", KeyHeader: "X-Client", RequestsPerSecond: 0.001, Burst: 3},
+		{Name: "reports", MethodsPatterns: []string{"reports/*/*"}, KeyHeader: "X-Client", RequestsPerSecond: 0.001, Burst: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ReceiveConfiguration(nil) }()
+
+	if err := Check(requestCtx("a"), "reports/daily/get"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := Check(requestCtx("a"), "reports/daily/get"); err == nil {
+			t.Fatalf("call %d must be rejected by the reports rule", i)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := Check(requestCtx("a"), "catalog/item/get"); err != nil {
+			t.Fatalf("call %d must get the tokens not taken by rejected calls: %v", i, err)
+		}
+	}
+	if err := Check(requestCtx("a"), "catalog/item/get"); err == nil {
+		t.Fatal("burst of the client rule must be exhausted")
+	}
+}
+
+func TestReceiveConfiguration_KeepsBuckets(t *testing.T) {
+	service.InitMetrics()
+	limit := conf.RateLimitConfig{Name: "client", KeyHeader: "X-Client", RequestsPerSecond: 0.001, Burst: 1}
+	if err := ReceiveConfiguration([]conf.RateLimitConfig{limit}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ReceiveConfiguration(nil) }()
+	if err := Check(requestCtx("a"), "catalog/item/get"); err != nil {
+		t.Fatal(err)
+	}
+
+	limit.MethodsPatterns = []string{"catalog/*/*"}
+	if err := ReceiveConfiguration([]conf.RateLimitConfig{limit}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(requestCtx("a"), "catalog/item/get"); err == nil {
+		t.Fatal("reload with the same name, rate and burst must keep the buckets")
+	}
+
+	limit.Burst = 2
+	if err := ReceiveConfiguration([]conf.RateLimitConfig{limit}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(requestCtx("a"), "catalog/item/get"); err != nil {
+		t.Fatalf("rule with changed burst must start with full buckets: %v", err)
+	}
+}
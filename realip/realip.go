@@ -0,0 +1,157 @@
+package realip
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	headerForwardedFor   = "X-Forwarded-For"
+	headerForwardedProto = "X-Forwarded-Proto"
+	headerForwardedHost  = "X-Forwarded-Host"
+	headerRealIp         = "X-Real-Ip"
+
+	schemeHttp  = "http"
+	schemeHttps = "https"
+
+	userValueKey = "realip.address"
+)
+
+var (
+	trusted     Networks
+	trustedLock sync.RWMutex
+)
+
+// Address describes the original client of a request as it was seen by the first untrusted hop
+type Address struct {
+	IP     net.IP
+	Scheme string
+	Host   string
+}
+
+type Networks []*net.IPNet
+
+func (n Networks) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks accepts both CIDR notation and single addresses
+func ParseNetworks(list []string) (Networks, error) {
+	networks := make(Networks, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.Errorf("invalid ip address '%s'", s)
+			}
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid network '%s'", s)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func SetTrustedProxies(networks Networks) {
+	trustedLock.Lock()
+	trusted = networks
+	trustedLock.Unlock()
+}
+
+func IsTrustedProxy(ip net.IP) bool {
+	trustedLock.RLock()
+	defer trustedLock.RUnlock()
+	return trusted.Contains(ip)
+}
+
+// FromRequest resolves the client address once per request and caches it in the request context.
+// Forwarding headers are taken into account only when the direct peer is a trusted proxy
+func FromRequest(ctx *fasthttp.RequestCtx) Address {
+	if addr, ok := ctx.UserValue(userValueKey).(Address); ok {
+		return addr
+	}
+	addr := resolve(ctx)
+	ctx.SetUserValue(userValueKey, addr)
+	return addr
+}
+
+func resolve(ctx *fasthttp.RequestCtx) Address {
+	addr := Address{
+		IP:     ctx.RemoteIP(),
+		Scheme: schemeHttp,
+		Host:   string(ctx.Host()),
+	}
+	if ctx.IsTLS() {
+		addr.Scheme = schemeHttps
+	}
+	if !IsTrustedProxy(addr.IP) {
+		return addr
+	}
+
+	header := &ctx.Request.Header
+	if ip := clientFromForwardedFor(string(header.Peek(headerForwardedFor))); ip != nil {
+		addr.IP = ip
+	} else if ip := net.ParseIP(strings.TrimSpace(string(header.Peek(headerRealIp)))); ip != nil {
+		addr.IP = ip
+	}
+	if proto := strings.ToLower(firstValue(header.Peek(headerForwardedProto))); proto == schemeHttp || proto == schemeHttps {
+		addr.Scheme = proto
+	}
+	if host := firstValue(header.Peek(headerForwardedHost)); host != "" {
+		addr.Host = host
+	}
+	return addr
+}
+
+// clientFromForwardedFor walks the chain from right to left, skipping trusted proxies,
+// because only the rightmost entries were appended by the infrastructure we trust
+func clientFromForwardedFor(value string) net.IP {
+	if value == "" {
+		return nil
+	}
+	hops := strings.Split(value, ",")
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !IsTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+func firstValue(value []byte) string {
+	s := string(value)
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
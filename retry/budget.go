@@ -0,0 +1,81 @@
+package retry
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	budgetBuckets = 10
+)
+
+// Budget allows retries while they stay within a share of calls over the last ten seconds,
+// a minimum rate of retries is always allowed so that low traffic methods can be retried too
+type Budget struct {
+	ratio      float64
+	minRetries int
+
+	lock     sync.Mutex
+	now      func() time.Time
+	buckets  [budgetBuckets]bucket
+	position int64
+}
+
+type bucket struct {
+	calls   int
+	retries int
+}
+
+func NewBudget(ratio float64, minRetriesPerSecond int) *Budget {
+	return &Budget{ratio: ratio, minRetries: minRetriesPerSecond * budgetBuckets, now: time.Now}
+}
+
+// Configure changes limits of the budget, calls and retries already counted are kept
+func (b *Budget) Configure(ratio float64, minRetriesPerSecond int) {
+	b.lock.Lock()
+	b.ratio, b.minRetries = ratio, minRetriesPerSecond*budgetBuckets
+	b.lock.Unlock()
+}
+
+func (b *Budget) advance() *bucket {
+	second := b.now().Unix()
+	if second-b.position >= budgetBuckets {
+		b.buckets = [budgetBuckets]bucket{}
+	} else {
+		for p := b.position + 1; p <= second; p++ {
+			b.buckets[p%budgetBuckets] = bucket{}
+		}
+	}
+	if second > b.position {
+		b.position = second
+	}
+	return &b.buckets[b.position%budgetBuckets]
+}
+
+func (b *Budget) Call() {
+	b.lock.Lock()
+	b.advance().calls++
+	b.lock.Unlock()
+}
+
+// Withdraw reports whether one more retry fits the budget and counts it if so
+func (b *Budget) Withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	current := b.advance()
+	calls, retries := 0, 0
+	for _, bucket := range b.buckets {
+		calls += bucket.calls
+		retries += bucket.retries
+	}
+	allowed := int(float64(calls) * b.ratio)
+	if allowed < b.minRetries {
+		allowed = b.minRetries
+	}
+	if retries >= allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
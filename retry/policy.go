@@ -0,0 +1,97 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/service"
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent marks the error of an attempt as final, it is returned without further retries
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type Policy struct {
+	methods        service.MethodMatcher
+	codes          map[codes.Code]bool
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+
+	onRetry           func()
+	onBudgetExhausted func()
+}
+
+// ParseCode accepts names as in grpc-go 'Unavailable' and as in the grpc spec 'UNAVAILABLE'
+func ParseCode(name string) (codes.Code, error) {
+	normalized := strings.ToLower(strings.Replace(name, "_", "", -1))
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.ToLower(c.String()) == normalized {
+			return c, nil
+		}
+	}
+	return codes.Unknown, errors.Errorf("unknown grpc code '%s'", name)
+}
+
+// backoff is a full jitter exponential backoff, the attempt starts from 1
+func (p *Policy) backoff(attempt int) time.Duration {
+	limit := float64(p.initialBackoff) * math.Pow(p.multiplier, float64(attempt-1))
+	if limit > float64(p.maxBackoff) {
+		limit = float64(p.maxBackoff)
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// Do calls the function until it succeeds, the error is not retryable, attempts or budget are exhausted
+// or the next backoff does not fit the deadline of the context. The call is expected to be counted in the budget
+func (p *Policy) Do(ctx context.Context, budget *Budget, call func(ctx context.Context, attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := call(ctx, attempt)
+		if err == nil {
+			return nil
+		}
+		if permanent, ok := err.(permanentError); ok {
+			return permanent.err
+		}
+		if attempt >= p.maxAttempts || !p.codes[status.Code(err)] || ctx.Err() != nil {
+			return err
+		}
+
+		pause := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= pause {
+			return err
+		}
+		if !budget.Withdraw() {
+			if p.onBudgetExhausted != nil {
+				p.onBudgetExhausted()
+			}
+			return err
+		}
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if p.onRetry != nil {
+			p.onRetry()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package retry
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"isp-convert-service/conf"
+	"isp-convert-service/service"
+)
+
+var (
+	retrier     *Retrier
+	retrierLock sync.RWMutex
+	// retryBudget outlives configurations, so that a reload doesn't allow a new burst of retries
+	retryBudget = NewBudget(0, 0)
+)
+
+type Retrier struct {
+	policies []*Policy
+	budget   *Budget
+}
+
+func NewRetrier(cfg conf.RetriesConfig, budget *Budget) (*Retrier, error) {
+	r := &Retrier{
+		policies: make([]*Policy, 0, len(cfg.Policies)),
+		budget:   budget,
+	}
+	for i, p := range cfg.Policies {
+		if len(p.MethodsPatterns) == 0 {
+			return nil, errors.Errorf("retry policy %d: methods are not specified", i)
+		}
+		policy := &Policy{
+			methods:        service.NewCacheableMethodMatcher(p.MethodsPatterns),
+			codes:          make(map[codes.Code]bool),
+			maxAttempts:    p.GetMaxAttempts(),
+			initialBackoff: p.GetInitialBackoff(),
+			maxBackoff:     p.GetMaxBackoff(),
+			multiplier:     p.GetBackoffMultiplier(),
+
+			onRetry:           func() { service.GetMetrics().UpdateRetry() },
+			onBudgetExhausted: func() { service.GetMetrics().UpdateRetryBudgetExhausted() },
+		}
+		for _, name := range p.GetCodes() {
+			code, err := ParseCode(name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "retry policy %d", i)
+			}
+			policy.codes[code] = true
+		}
+		r.policies = append(r.policies, policy)
+	}
+	return r, nil
+}
+
+func ReceiveConfiguration(cfg conf.RetriesConfig) error {
+	next, err := NewRetrier(cfg, retryBudget)
+	if err != nil {
+		return err
+	}
+	retryBudget.Configure(float64(cfg.GetBudgetPercent())/100, cfg.GetMinRetriesPerSecond())
+	retrierLock.Lock()
+	retrier = next
+	retrierLock.Unlock()
+	return nil
+}
+
+// Do applies the first policy matching the method, methods without policy are called once.
+// Every call is counted in the budget, so retries are limited by a share of all proxied traffic.
+// Callers must not use it for multipart uploads, their body is a stream and can not be sent again
+func Do(ctx context.Context, method string, call func(ctx context.Context, attempt int) error) error {
+	retrierLock.RLock()
+	r := retrier
+	retrierLock.RUnlock()
+	if r == nil {
+		retryBudget.Call()
+	} else {
+		r.budget.Call()
+		for _, p := range r.policies {
+			if p.methods.Match(method) {
+				return p.Do(ctx, r.budget, call)
+			}
+		}
+	}
+	return call(ctx, 1)
+}
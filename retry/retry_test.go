@@ -0,0 +1,156 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"isp-convert-service/conf"
+)
+
+func TestParseCode(t *testing.T) {
+	for name, expected := range map[string]codes.Code{
+		"Unavailable":       codes.Unavailable,
+		"UNAVAILABLE":       codes.Unavailable,
+		"DEADLINE_EXCEEDED": codes.DeadlineExceeded,
+		"ResourceExhausted": codes.ResourceExhausted,
+	} {
+		if code, err := ParseCode(name); err != nil || code != expected {
+			t.Errorf("%s: expected %v, got %v, %v", name, expected, code, err)
+		}
+	}
+	if _, err := ParseCode("Busy"); err == nil {
+		t.Error("expected error for unknown code")
+	}
+}
+
+func TestBudget_Withdraw(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBudget(0.2, 1)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		b.Call()
+	}
+	for i := 0; i < 20; i++ {
+		if !b.Withdraw() {
+			t.Fatalf("retry %d must fit the budget", i)
+		}
+	}
+	if b.Withdraw() {
+		t.Fatal("retries over the budget must be rejected")
+	}
+
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 10; i++ {
+		if !b.Withdraw() {
+			t.Fatalf("minimum retry %d must be allowed without traffic", i)
+		}
+	}
+	if b.Withdraw() {
+		t.Fatal("retries over the minimum must be rejected")
+	}
+}
+
+func TestPolicy_Do(t *testing.T) {
+	p := &Policy{
+		codes:          map[codes.Code]bool{codes.Unavailable: true},
+		maxAttempts:    3,
+		initialBackoff: time.Millisecond,
+		maxBackoff:     time.Millisecond,
+		multiplier:     2,
+	}
+	budget := NewBudget(1, 10)
+
+	calls := 0
+	err := p.Do(context.Background(), budget, func(ctx context.Context, attempt int) error {
+		calls++
+		return status.Error(codes.Unavailable, "redeploy")
+	})
+	if status.Code(err) != codes.Unavailable || calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d: %v", calls, err)
+	}
+
+	calls = 0
+	err = p.Do(context.Background(), budget, func(ctx context.Context, attempt int) error {
+		calls++
+		return status.Error(codes.InvalidArgument, "bad request")
+	})
+	if status.Code(err) != codes.InvalidArgument || calls != 1 {
+		t.Fatalf("non retryable code must not be retried, got %d attempts", calls)
+	}
+
+	calls = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Microsecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	_ = p.Do(ctx, budget, func(ctx context.Context, attempt int) error {
+		calls++
+		return status.Error(codes.Unavailable, "redeploy")
+	})
+	if calls != 1 {
+		t.Fatalf("expired deadline must stop retries, got %d attempts", calls)
+	}
+}
+
+func TestReceiveConfiguration_KeepsBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	retryBudget.now = func() time.Time { return now }
+	defer func() {
+		retryBudget.now = time.Now
+	}()
+
+	cfg := conf.RetriesConfig{BudgetPercent: 10, MinRetriesPerSecond: 1}
+	if err := ReceiveConfiguration(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for retryBudget.Withdraw() {
+	}
+
+	if err := ReceiveConfiguration(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if retryBudget.Withdraw() {
+		t.Error("reload must not reset the budget")
+	}
+
+	cfg.MinRetriesPerSecond = 2
+	if err := ReceiveConfiguration(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !retryBudget.Withdraw() {
+		t.Error("reload is expected to apply new limits of the budget")
+	}
+}
+
+func TestDo_CountsAllCalls(t *testing.T) {
+	budget := NewBudget(0.1, 0)
+	r, err := NewRetrier(conf.RetriesConfig{Policies: []conf.RetryPolicyConfig{
+		{MethodsPatterns: []string{"reports/*/*"}},
+	}}, budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retrierLock.Lock()
+	retrier = r
+	retrierLock.Unlock()
+	defer func() {
+		retrierLock.Lock()
+		retrier = nil
+		retrierLock.Unlock()
+	}()
+
+	for i := 0; i < 10; i++ {
+		_ = Do(context.Background(), "catalog/item/get", func(ctx context.Context, attempt int) error {
+			return nil
+		})
+	}
+	if !budget.Withdraw() {
+		t.Fatal("calls of methods without policy must count to the budget")
+	}
+	if budget.Withdraw() {
+		t.Fatal("retries over the share of all calls must be rejected")
+	}
+}
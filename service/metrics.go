@@ -5,6 +5,7 @@ import (
 	"github.com/rcrowley/go-metrics"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +15,8 @@ const (
 
 var (
 	mh *metricHolder
+
+	routerObserver atomic.Value
 )
 
 type metricHolder struct {
@@ -23,6 +26,14 @@ type metricHolder struct {
 	statusLock         sync.RWMutex
 	routerResponseTime metrics.Histogram
 	responseTime       metrics.Histogram
+	namedCounters      map[string]metrics.Counter
+	namedLock          sync.RWMutex
+	namedGauges        map[string]metrics.Gauge
+	gaugeLock          sync.RWMutex
+	namedHistograms    map[string]metrics.Histogram
+	histogramLock      sync.RWMutex
+	adaptiveLimit      metrics.Gauge
+	adaptiveRejected   metrics.Counter
 }
 
 func (mh *metricHolder) UpdateMethodResponseTime(uri string, time time.Duration) {
@@ -37,10 +48,111 @@ func (mh *metricHolder) UpdateRouterResponseTime(time time.Duration) {
 	mh.routerResponseTime.Update(int64(time))
 }
 
+// ObserveRouterResponseTime passes the response time of a unary router call to the observer
+func (mh *metricHolder) ObserveRouterResponseTime(rtt time.Duration) {
+	if observer, ok := routerObserver.Load().(RouterResponseTimeObserver); ok {
+		observer(rtt)
+	}
+}
+
 func (mh *metricHolder) UpdateStatusCounter(status int) {
 	mh.getOrRegisterCounter(status).Inc(1)
 }
 
+func (mh *metricHolder) UpdateRateLimitRejected(rule string) {
+	mh.getOrRegisterNamedCounter("http.ratelimit.rejected." + rule).Inc(1)
+}
+
+func (mh *metricHolder) UpdatePriorityShed(tier string) {
+	mh.getOrRegisterNamedCounter("http.priority.shed." + tier).Inc(1)
+}
+
+func (mh *metricHolder) UpdateRetry() {
+	mh.getOrRegisterNamedCounter("grpc.router.retry").Inc(1)
+}
+
+func (mh *metricHolder) UpdateRetryBudgetExhausted() {
+	mh.getOrRegisterNamedCounter("grpc.router.retry.budget_exhausted").Inc(1)
+}
+
+func (mh *metricHolder) UpdateHedge() {
+	mh.getOrRegisterNamedCounter("grpc.router.hedge").Inc(1)
+}
+
+func (mh *metricHolder) UpdateHedgeWon() {
+	mh.getOrRegisterNamedCounter("grpc.router.hedge.won").Inc(1)
+}
+
+func (mh *metricHolder) UpdateHedgeBudgetExhausted() {
+	mh.getOrRegisterNamedCounter("grpc.router.hedge.budget_exhausted").Inc(1)
+}
+
+// UpdateRouterInstance reports availability of the router instance: 1 - available, 0 - disconnected, unhealthy or ejected
+func (mh *metricHolder) UpdateRouterInstance(address string, available bool, outstanding int) {
+	value := int64(0)
+	if available {
+		value = 1
+	}
+	mh.getOrRegisterNamedGauge("grpc.router.instance.available_" + address).Update(value)
+	mh.getOrRegisterNamedGauge("grpc.router.instance.outstanding_" + address).Update(int64(outstanding))
+}
+
+// RemoveRouterInstance unregisters gauges of the router instance removed from configuration
+func (mh *metricHolder) RemoveRouterInstance(address string) {
+	mh.unregisterNamedGauge("grpc.router.instance.available_" + address)
+	mh.unregisterNamedGauge("grpc.router.instance.outstanding_" + address)
+}
+
+func (mh *metricHolder) UpdateRouterInstanceEjected(address string) {
+	mh.getOrRegisterNamedCounter("grpc.router.instance.ejected_" + address).Inc(1)
+}
+
+func (mh *metricHolder) UpdateRouterFailover(cluster string) {
+	mh.getOrRegisterNamedCounter("grpc.router.failover_" + cluster).Inc(1)
+}
+
+// UpdateRouterFailoverActive sets 1 while calls of the cluster go to its standby
+func (mh *metricHolder) UpdateRouterFailoverActive(cluster string, active bool) {
+	value := int64(0)
+	if active {
+		value = 1
+	}
+	mh.getOrRegisterNamedGauge("grpc.router.failover.active_" + cluster).Update(value)
+}
+
+// UpdateSplit reports the result of the request sent to the variant of the traffic split
+func (mh *metricHolder) UpdateSplit(split, variant string, failed bool, time time.Duration) {
+	prefix := "http.split." + split + "." + variant
+	mh.getOrRegisterNamedCounter(prefix + ".count").Inc(1)
+	if failed {
+		mh.getOrRegisterNamedCounter(prefix + ".errors").Inc(1)
+	}
+	mh.getOrRegisterNamedHistogram(prefix + ".time").Update(int64(time))
+}
+
+// UpdateMirror counts shadow calls of the mirror by result: sent, dropped, error or mismatch
+func (mh *metricHolder) UpdateMirror(mirror, result string) {
+	mh.getOrRegisterNamedCounter("grpc.mirror." + mirror + "." + result).Inc(1)
+}
+
+// UpdateBreakerState sets the state of the method breaker: 0 - closed, 1 - open, 2 - half-open
+func (mh *metricHolder) UpdateBreakerState(method string, state int) {
+	mh.getOrRegisterNamedGauge("grpc.breaker.state_" + method).Update(int64(state))
+}
+
+// RemoveBreakerState unregisters the gauge of the dropped method breaker
+func (mh *metricHolder) RemoveBreakerState(method string) {
+	mh.unregisterNamedGauge("grpc.breaker.state_" + method)
+}
+
+func (mh *metricHolder) UpdateAdaptiveLimit(limit int) {
+	mh.adaptiveLimit.Update(int64(limit))
+}
+
+func (mh *metricHolder) UpdateAdaptiveLimitRejected() {
+	mh.adaptiveRejected.Inc(1)
+}
+
 func (mh *metricHolder) getOrRegisterHistogram(uri string) metrics.Histogram {
 	mh.methodLock.RLock()
 	histogram, ok := mh.methodHistograms[uri]
@@ -81,6 +193,74 @@ func (mh *metricHolder) getOrRegisterCounter(status int) metrics.Counter {
 	return d
 }
 
+func (mh *metricHolder) getOrRegisterNamedCounter(name string) metrics.Counter {
+	mh.namedLock.RLock()
+	d, ok := mh.namedCounters[name]
+	mh.namedLock.RUnlock()
+	if ok {
+		return d
+	}
+
+	mh.namedLock.Lock()
+	defer mh.namedLock.Unlock()
+	if d, ok := mh.namedCounters[name]; ok {
+		return d
+	}
+	d = metrics.GetOrRegisterCounter(name, metric.GetRegistry())
+	mh.namedCounters[name] = d
+	return d
+}
+
+func (mh *metricHolder) getOrRegisterNamedGauge(name string) metrics.Gauge {
+	mh.gaugeLock.RLock()
+	d, ok := mh.namedGauges[name]
+	mh.gaugeLock.RUnlock()
+	if ok {
+		return d
+	}
+
+	mh.gaugeLock.Lock()
+	defer mh.gaugeLock.Unlock()
+	if d, ok := mh.namedGauges[name]; ok {
+		return d
+	}
+	d = metrics.GetOrRegisterGauge(name, metric.GetRegistry())
+	mh.namedGauges[name] = d
+	return d
+}
+
+func (mh *metricHolder) unregisterNamedGauge(name string) {
+	mh.gaugeLock.Lock()
+	defer mh.gaugeLock.Unlock()
+	delete(mh.namedGauges, name)
+	metric.GetRegistry().Unregister(name)
+}
+
+func (mh *metricHolder) getOrRegisterNamedHistogram(name string) metrics.Histogram {
+	mh.histogramLock.RLock()
+	d, ok := mh.namedHistograms[name]
+	mh.histogramLock.RUnlock()
+	if ok {
+		return d
+	}
+
+	mh.histogramLock.Lock()
+	defer mh.histogramLock.Unlock()
+	if d, ok := mh.namedHistograms[name]; ok {
+		return d
+	}
+	d = metrics.GetOrRegisterHistogram(name, metric.GetRegistry(), metrics.NewUniformSample(defaultSampleSize))
+	mh.namedHistograms[name] = d
+	return d
+}
+
+// RouterResponseTimeObserver receives response times of unary router calls, streams are not observed
+type RouterResponseTimeObserver func(time.Duration)
+
+func SetRouterResponseTimeObserver(observer RouterResponseTimeObserver) {
+	routerObserver.Store(observer)
+}
+
 func GetMetrics() *metricHolder {
 	return mh
 }
@@ -90,12 +270,17 @@ func InitMetrics() {
 		mh = &metricHolder{
 			methodHistograms: make(map[string]metrics.Histogram),
 			statusCounters:   make(map[int]metrics.Counter),
+			namedCounters:    make(map[string]metrics.Counter),
+			namedGauges:      make(map[string]metrics.Gauge),
+			namedHistograms:  make(map[string]metrics.Histogram),
 			responseTime: metrics.GetOrRegisterHistogram(
 				"http.response.time", metric.GetRegistry(), metrics.NewUniformSample(defaultSampleSize),
 			),
 			routerResponseTime: metrics.GetOrRegisterHistogram(
 				"grpc.router.response.time", metric.GetRegistry(), metrics.NewUniformSample(defaultSampleSize),
 			),
+			adaptiveLimit:    metrics.GetOrRegisterGauge("grpc.router.adaptive.limit", metric.GetRegistry()),
+			adaptiveRejected: metrics.GetOrRegisterCounter("grpc.router.adaptive.rejected", metric.GetRegistry()),
 		}
 	}
 }
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"isp-convert-service/conf"
+	"isp-convert-service/quota"
 	"isp-convert-service/utils"
 
 	"github.com/integration-system/isp-lib/backend"
@@ -39,7 +40,7 @@ func SendMultipartData(ctx *fasthttp.RequestCtx, method string) {
 	timeout := cfg.GetStreamInvokeTimeout()
 	bufferSize := cfg.GetTransferFileBufferSize()
 
-	stream, cancel, err := openStream(&ctx.Request.Header, method, timeout)
+	stream, cancel, err := openStream(ctx, method, timeout)
 	defer func() {
 		if cancel != nil {
 			cancel()
@@ -138,7 +139,7 @@ func GetFile(ctx *fasthttp.RequestCtx, method string) {
 		return
 	}
 
-	stream, cancel, err := openStream(&ctx.Request.Header, method, timeout)
+	stream, cancel, err := openStream(ctx, method, timeout)
 	defer func() {
 		if cancel != nil {
 			cancel()
@@ -213,14 +214,15 @@ func GetFile(ctx *fasthttp.RequestCtx, method string) {
 	}
 }
 
-func openStream(headers *fasthttp.RequestHeader, method string, timeout time.Duration) (isp.BackendService_RequestStreamClient, context.CancelFunc, error) {
-	client, err := utils.GetGrpcClient()
+func openStream(reqCtx *fasthttp.RequestCtx, method string, timeout time.Duration) (isp.BackendService_RequestStreamClient, context.CancelFunc, error) {
+	md, _ := utils.MakeMetadata(reqCtx, method)
+	client, route, err := utils.GetGrpcClient(reqCtx, utils.MethodKey(reqCtx))
 	if err != nil {
+		quota.Refund(reqCtx)
 		return nil, nil, err
 	}
-	md, _ := utils.MakeMetadata(headers, method)
 	ctx := metadata.NewOutgoingContext(context.Background(), md)
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	ctx, cancel := context.WithTimeout(ctx, route.StreamInvokeTimeout(timeout))
 	stream, err := client.RequestStream(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,135 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Reloadable is a file based resource that can be refreshed from disk without recreating its consumers
+type Reloadable interface {
+	// Reload rereads files if they were modified since the last load.
+	// On error the previously loaded value stays in use
+	Reload() (bool, error)
+}
+
+type KeyPair struct {
+	certFile string
+	keyFile  string
+
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+func NewKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	kp := &KeyPair{certFile: certFile, keyFile: keyFile}
+	if _, err := kp.Reload(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+func (kp *KeyPair) Reload() (bool, error) {
+	modTime, err := lastModTime(kp.certFile, kp.keyFile)
+	if err != nil {
+		return false, err
+	}
+	kp.lock.RLock()
+	unchanged := kp.certificate != nil && modTime.Equal(kp.modTime)
+	kp.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "load key pair %s, %s", kp.certFile, kp.keyFile)
+	}
+	if len(certificate.Certificate) > 0 {
+		certificate.Leaf, _ = x509.ParseCertificate(certificate.Certificate[0])
+	}
+
+	kp.lock.Lock()
+	kp.certificate = &certificate
+	kp.modTime = modTime
+	kp.lock.Unlock()
+	return true, nil
+}
+
+func (kp *KeyPair) Certificate() *tls.Certificate {
+	kp.lock.RLock()
+	defer kp.lock.RUnlock()
+	return kp.certificate
+}
+
+type CertPool struct {
+	files []string
+
+	lock    sync.RWMutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func NewCertPool(files ...string) (*CertPool, error) {
+	cp := &CertPool{files: files}
+	if _, err := cp.Reload(); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *CertPool) Reload() (bool, error) {
+	modTime, err := lastModTime(cp.files...)
+	if err != nil {
+		return false, err
+	}
+	cp.lock.RLock()
+	unchanged := cp.pool != nil && modTime.Equal(cp.modTime)
+	cp.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	pool := x509.NewCertPool()
+	for _, file := range cp.files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return false, errors.Wrapf(err, "read ca bundle %s", file)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return false, errors.Errorf("no certificates found in ca bundle %s", file)
+		}
+	}
+
+	cp.lock.Lock()
+	cp.pool = pool
+	cp.modTime = modTime
+	cp.lock.Unlock()
+	return true, nil
+}
+
+func (cp *CertPool) Pool() *x509.CertPool {
+	cp.lock.RLock()
+	defer cp.lock.RUnlock()
+	return cp.pool
+}
+
+func lastModTime(files ...string) (time.Time, error) {
+	last := time.Time{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "stat %s", file)
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,238 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"isp-convert-service/conf"
+)
+
+const (
+	securityProtocolTls      = "tls"
+	securityProtocolInsecure = "insecure"
+)
+
+type ClientSettings struct {
+	EnableTls          bool
+	CaFiles            []string
+	CertFile           string
+	KeyFile            string
+	ServerNameOverride string
+	MinVersion         string
+	ReloadInterval     time.Duration
+}
+
+func NewClientSettings(cfg conf.GrpcTransportConfig) ClientSettings {
+	return ClientSettings{
+		EnableTls:          cfg.EnableTls,
+		CaFiles:            cfg.CaFiles,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+		ServerNameOverride: cfg.ServerNameOverride,
+		MinVersion:         cfg.MinVersion,
+		ReloadInterval:     cfg.GetCertificateReloadPeriod(),
+	}
+}
+
+// ClientCredentials are grpc transport credentials which can be reconfigured after the connection was dialed.
+// Rotated certificates are used for new handshakes, established connections are kept.
+// Clients which can redial should use DialOption, so that plaintext connections are dialed with grpc.WithInsecure
+type ClientCredentials struct {
+	state *clientState
+}
+
+// insecureAuthInfo is returned by the plaintext handshake of connections which were dialed with these credentials
+type insecureAuthInfo struct{}
+
+func (insecureAuthInfo) AuthType() string {
+	return securityProtocolInsecure
+}
+
+type clientState struct {
+	lock       sync.RWMutex
+	enabled    bool
+	minVersion uint16
+	serverName string
+	roots      *CertPool
+	keyPair    *KeyPair
+	watcher    *Watcher
+}
+
+func NewClientCredentials() *ClientCredentials {
+	return &ClientCredentials{state: &clientState{}}
+}
+
+// LoadedClientSettings hold certificates loaded for ClientCredentials, their files are watched only once applied
+type LoadedClientSettings struct {
+	state *clientState
+}
+
+// LoadClientSettings reads certificates of the settings without changing any credentials
+func LoadClientSettings(settings ClientSettings, onReload func(resource Reloadable, err error)) (LoadedClientSettings, error) {
+	next := &clientState{enabled: settings.EnableTls, serverName: settings.ServerNameOverride}
+	if settings.EnableTls {
+		var err error
+		if next.minVersion, err = ParseVersion(settings.MinVersion); err != nil {
+			return LoadedClientSettings{}, err
+		}
+		resources := make([]Reloadable, 0, 2)
+		if len(settings.CaFiles) > 0 {
+			if next.roots, err = NewCertPool(settings.CaFiles...); err != nil {
+				return LoadedClientSettings{}, err
+			}
+			resources = append(resources, next.roots)
+		}
+		if settings.CertFile != "" || settings.KeyFile != "" {
+			if next.keyPair, err = NewKeyPair(settings.CertFile, settings.KeyFile); err != nil {
+				return LoadedClientSettings{}, err
+			}
+			resources = append(resources, next.keyPair)
+		}
+		if len(resources) > 0 {
+			next.watcher = NewWatcher(settings.ReloadInterval, onReload, resources...)
+		}
+	}
+	return LoadedClientSettings{state: next}, nil
+}
+
+// Configure applies new settings and reports whether TLS was switched on or off,
+// in that case connections must be redialed to use the new mode
+func (c *ClientCredentials) Configure(settings ClientSettings, onReload func(resource Reloadable, err error)) (bool, error) {
+	loaded, err := LoadClientSettings(settings, onReload)
+	if err != nil {
+		return false, err
+	}
+	return c.Apply(loaded), nil
+}
+
+// Apply switches the credentials to loaded settings, it reports whether TLS was switched on or off
+func (c *ClientCredentials) Apply(loaded LoadedClientSettings) bool {
+	next := loaded.state
+	state := c.state
+	state.lock.Lock()
+	modeChanged := state.enabled != next.enabled
+	if state.watcher != nil {
+		state.watcher.Stop()
+	}
+	state.enabled = next.enabled
+	state.minVersion = next.minVersion
+	state.serverName = next.serverName
+	state.roots = next.roots
+	state.keyPair = next.keyPair
+	state.watcher = next.watcher
+	if state.watcher != nil {
+		state.watcher.Start()
+	}
+	state.lock.Unlock()
+	return modeChanged
+}
+
+// DialOption returns plaintext transport while TLS is disabled, otherwise the credentials themselves.
+// Configure reports when the mode is switched and connections must be redialed with a new option
+func (c *ClientCredentials) DialOption() grpc.DialOption {
+	c.state.lock.RLock()
+	enabled := c.state.enabled
+	c.state.lock.RUnlock()
+	if !enabled {
+		return grpc.WithInsecure()
+	}
+	return grpc.WithTransportCredentials(c)
+}
+
+func (c *ClientCredentials) Close() {
+	c.state.lock.Lock()
+	defer c.state.lock.Unlock()
+	if c.state.watcher != nil {
+		c.state.watcher.Stop()
+		c.state.watcher = nil
+	}
+}
+
+func (c *ClientCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	config, ok := c.tlsConfig(authority)
+	if !ok {
+		// the client was dialed with the credentials and can't be redialed, e.g. journal
+		return rawConn, insecureAuthInfo{}, nil
+	}
+
+	conn := tls.Client(rawConn, config)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- conn.Handshake()
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			_ = rawConn.Close()
+			return nil, nil, errors.Wrap(err, "tls handshake")
+		}
+	case <-ctx.Done():
+		_ = rawConn.Close()
+		return nil, nil, ctx.Err()
+	}
+	return conn, credentials.TLSInfo{State: conn.ConnectionState()}, nil
+}
+
+func (c *ClientCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("server handshake is not supported by client credentials")
+}
+
+func (c *ClientCredentials) Info() credentials.ProtocolInfo {
+	c.state.lock.RLock()
+	defer c.state.lock.RUnlock()
+	info := credentials.ProtocolInfo{SecurityProtocol: securityProtocolInsecure}
+	if c.state.enabled {
+		info.SecurityProtocol = securityProtocolTls
+		info.ServerName = c.state.serverName
+	}
+	return info
+}
+
+// Clone shares the state, so that reconfiguration is visible to every connection
+func (c *ClientCredentials) Clone() credentials.TransportCredentials {
+	return &ClientCredentials{state: c.state}
+}
+
+func (c *ClientCredentials) OverrideServerName(serverName string) error {
+	c.state.lock.Lock()
+	c.state.serverName = serverName
+	c.state.lock.Unlock()
+	return nil
+}
+
+func (c *ClientCredentials) tlsConfig(authority string) (*tls.Config, bool) {
+	state := c.state
+	state.lock.RLock()
+	defer state.lock.RUnlock()
+	if !state.enabled {
+		return nil, false
+	}
+
+	serverName := state.serverName
+	if serverName == "" {
+		serverName = authority
+		if host, _, err := net.SplitHostPort(authority); err == nil {
+			serverName = host
+		}
+	}
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: state.minVersion,
+		NextProtos: []string{"h2"},
+	}
+	if state.roots != nil {
+		config.RootCAs = state.roots.Pool()
+	}
+	if keyPair := state.keyPair; keyPair != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return keyPair.Certificate(), nil
+		}
+	}
+	return config, true
+}
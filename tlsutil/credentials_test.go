@@ -0,0 +1,113 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+func TestClientCredentials_Configure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	writeCertificate(t, certFile, keyFile, "router.local", time.Now())
+
+	c := NewClientCredentials()
+	defer c.Close()
+	if c.Info().SecurityProtocol != securityProtocolInsecure {
+		t.Fatal("new credentials are expected to be plaintext")
+	}
+
+	if _, err := c.Configure(ClientSettings{EnableTls: true, CaFiles: []string{filepath.Join(dir, "missing.crt")}}, nil); err == nil {
+		t.Fatal("expected error for missing ca file")
+	}
+	if c.Info().SecurityProtocol != securityProtocolInsecure {
+		t.Fatal("invalid settings are expected to keep the previous mode")
+	}
+
+	modeChanged, err := c.Configure(ClientSettings{EnableTls: true, CaFiles: []string{certFile}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modeChanged || c.Info().SecurityProtocol != securityProtocolTls {
+		t.Fatal("enabling TLS is expected to change the mode")
+	}
+	if modeChanged, _ := c.Configure(ClientSettings{EnableTls: true, CaFiles: []string{certFile}}, nil); modeChanged {
+		t.Fatal("same mode is not expected to require redial")
+	}
+	if modeChanged, _ := c.Configure(ClientSettings{}, nil); !modeChanged {
+		t.Fatal("disabling TLS is expected to change the mode")
+	}
+}
+
+func TestClientCredentials_ServerName(t *testing.T) {
+	c := NewClientCredentials()
+	if _, err := c.Configure(ClientSettings{EnableTls: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := c.tlsConfig("10.0.0.1:9000"); config.ServerName != "10.0.0.1" {
+		t.Fatalf("expected host of authority, got %s", config.ServerName)
+	}
+	if err := c.Clone().OverrideServerName("router.local"); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := c.tlsConfig("10.0.0.1:9000"); config.ServerName != "router.local" {
+		t.Fatalf("expected overridden server name, got %s", config.ServerName)
+	}
+}
+
+func TestClientCredentials_ClientHandshake(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCertificate(t, certFile, keyFile, "router.local", time.Now())
+	serverPair, err := NewKeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClientCredentials()
+	defer c.Close()
+	clientConn, serverConn := net.Pipe()
+	conn, info, err := c.ClientHandshake(context.Background(), "router.local:9000", clientConn)
+	if err != nil || conn != clientConn || info.AuthType() != securityProtocolInsecure {
+		t.Fatalf("plaintext handshake is expected to return the raw connection with insecure auth info, got %v, %v", info, err)
+	}
+	_ = clientConn.Close()
+	_ = serverConn.Close()
+
+	if _, err := c.Configure(ClientSettings{EnableTls: true, CaFiles: []string{certFile}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	clientConn, serverConn = net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	go func() {
+		server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{*serverPair.Certificate()}})
+		_ = server.Handshake()
+	}()
+	_, info, err = c.ClientHandshake(context.Background(), "router.local:9000", clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsInfo, ok := info.(credentials.TLSInfo)
+	if !ok || tlsInfo.State.ServerName != "router.local" {
+		t.Fatalf("expected tls auth info for router.local, got %v", info)
+	}
+
+	wrongName, serverConn2 := net.Pipe()
+	defer serverConn2.Close()
+	go func() {
+		server := tls.Server(serverConn2, &tls.Config{Certificates: []tls.Certificate{*serverPair.Certificate()}})
+		_ = server.Handshake()
+	}()
+	if _, _, err := c.ClientHandshake(context.Background(), "other.local:9000", wrongName); err == nil {
+		t.Fatal("expected handshake error for certificate of another name")
+	}
+}
@@ -0,0 +1,84 @@
+package tlsutil
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/integration-system/isp-log"
+	"isp-convert-service/log_code"
+)
+
+const (
+	DefaultReloadInterval = 30 * time.Second
+)
+
+// Watcher periodically polls files of registered resources.
+// Polling is used instead of fs notifications because secrets are usually replaced by symlink swapping
+type Watcher struct {
+	interval  time.Duration
+	resources []Reloadable
+	onReload  func(resource Reloadable, err error)
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func NewWatcher(interval time.Duration, onReload func(resource Reloadable, err error), resources ...Reloadable) *Watcher {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	return &Watcher{
+		interval:  interval,
+		resources: resources,
+		onReload:  onReload,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (w *Watcher) Start() {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.reload()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+func (w *Watcher) reload() {
+	for _, resource := range w.resources {
+		changed, err := resource.Reload()
+		if (changed || err != nil) && w.onReload != nil {
+			w.onReload(resource, err)
+		}
+	}
+}
+
+// LogReload returns a reload callback which writes the outcome to the service log
+func LogReload(owner string) func(resource Reloadable, err error) {
+	return func(resource Reloadable, err error) {
+		if err != nil {
+			log.Warnf(log_code.WarnTlsCertificateReload, "%s: could not reload certificates, previous ones stay in use: %v", owner, err)
+			return
+		}
+		switch r := resource.(type) {
+		case *KeyPair:
+			if cert := r.Certificate(); cert != nil && cert.Leaf != nil {
+				log.Infof(log_code.InfoTlsCertificateReloaded, "%s: certificate '%s' reloaded, valid until %s",
+					owner, cert.Leaf.Subject.CommonName, cert.Leaf.NotAfter)
+			}
+		case *CertPool:
+			log.Infof(log_code.InfoTlsCertificateReloaded, "%s: ca bundle reloaded", owner)
+		}
+	}
+}
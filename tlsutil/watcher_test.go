@@ -0,0 +1,144 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key, files get the given modification time
+func writeCertificate(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestKeyPair_Reload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	modTime := time.Now().Add(-time.Minute)
+	writeCertificate(t, certFile, keyFile, "first.local", modTime)
+
+	pair, err := NewKeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := pair.Reload(); changed || err != nil {
+		t.Fatalf("unchanged files are expected to be skipped, changed %v, err %v", changed, err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(keyFile, modTime.Add(time.Second), modTime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pair.Reload(); err == nil {
+		t.Fatal("expected error for broken key")
+	}
+	if name := pair.Certificate().Leaf.Subject.CommonName; name != "first.local" {
+		t.Fatalf("previous certificate is expected to stay in use, got %s", name)
+	}
+
+	writeCertificate(t, certFile, keyFile, "second.local", modTime.Add(2*time.Second))
+	if changed, err := pair.Reload(); !changed || err != nil {
+		t.Fatalf("expected reload, changed %v, err %v", changed, err)
+	}
+	if name := pair.Certificate().Leaf.Subject.CommonName; name != "second.local" {
+		t.Fatalf("expected reloaded certificate, got %s", name)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	modTime := time.Now().Add(-time.Minute)
+	writeCertificate(t, certFile, keyFile, "first.local", modTime)
+	pair, err := NewKeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan error, 1)
+	w := NewWatcher(5*time.Millisecond, func(resource Reloadable, err error) {
+		if resource != pair {
+			t.Error("unexpected resource")
+		}
+		select {
+		case reloaded <- err:
+		default:
+		}
+	}, pair)
+	w.Start()
+	defer w.Stop()
+
+	// the watcher may see the files half written, such attempts fail and are retried on the next tick
+	writeCertificate(t, certFile, keyFile, "second.local", modTime.Add(time.Second))
+	timeout := time.After(5 * time.Second)
+	for pair.Certificate().Leaf.Subject.CommonName != "second.local" {
+		select {
+		case <-reloaded:
+		case <-timeout:
+			t.Fatal("watcher has not reloaded the changed files")
+		}
+	}
+}
+
+func TestWatcher_Stop(t *testing.T) {
+	w := NewWatcher(0, nil)
+	if w.interval != DefaultReloadInterval {
+		t.Fatalf("expected default interval, got %s", w.interval)
+	}
+	w.Start()
+	w.Stop()
+	w.Stop()
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	requestMetadataKey = "utils.requestMetadata"
+)
+
+var (
+	reservedLock     sync.RWMutex
+	reservedByOwner  = make(map[string][]string)
+	reservedMetadata = make(map[string]bool)
+)
+
+// ReserveMetadataKeys declares keys which are filled only by the converter itself.
+// Headers with the same names sent by a client are never forwarded
+func ReserveMetadataKeys(owner string, keys ...string) {
+	reservedLock.Lock()
+	defer reservedLock.Unlock()
+
+	lowerKeys := make([]string, len(keys))
+	for i, key := range keys {
+		lowerKeys[i] = strings.ToLower(key)
+	}
+	reservedByOwner[owner] = lowerKeys
+
+	reservedMetadata = make(map[string]bool)
+	for _, keys := range reservedByOwner {
+		for _, key := range keys {
+			reservedMetadata[key] = true
+		}
+	}
+}
+
+func isReservedMetadataKey(key string) bool {
+	reservedLock.RLock()
+	defer reservedLock.RUnlock()
+	return reservedMetadata[key]
+}
+
+// SetRequestMetadata attaches a trusted value to the metadata which will be sent with the request to the router
+func SetRequestMetadata(ctx *fasthttp.RequestCtx, key, value string) {
+	md, ok := ctx.UserValue(requestMetadataKey).(metadata.MD)
+	if !ok {
+		md = metadata.MD{}
+		ctx.SetUserValue(requestMetadataKey, md)
+	}
+	md.Set(key, value)
+}
+
+func getRequestMetadata(ctx *fasthttp.RequestCtx) metadata.MD {
+	md, _ := ctx.UserValue(requestMetadataKey).(metadata.MD)
+	return md
+}
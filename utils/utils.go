@@ -11,7 +11,9 @@ import (
 	"net/http"
 	"strings"
 
+	"isp-convert-service/canary"
 	"isp-convert-service/invoker"
+	"isp-convert-service/realip"
 
 	"github.com/golang/protobuf/ptypes/struct"
 	"github.com/integration-system/isp-lib/backend"
@@ -27,12 +29,20 @@ import (
 
 const (
 	JsonContentType = "application/json; charset=utf-8"
+
+	ClientIpHeader     = "x-client-ip"
+	ClientSchemeHeader = "x-client-scheme"
+	ClientHostHeader   = "x-client-host"
 )
 
 var (
 	json = jsoniter.ConfigFastest
 )
 
+func init() {
+	ReserveMetadataKeys("client-address", ClientIpHeader, ClientSchemeHeader, ClientHostHeader)
+}
+
 func ReadJsonBody(ctx *fasthttp.RequestCtx) (interface{}, error) {
 	requestBody := ctx.Request.Body()
 	var body interface{}
@@ -71,15 +81,37 @@ func GetResponse(msg *isp.Message, err error) ([]byte, int, error) {
 	return byteResponse, http.StatusOK, err
 }
 
-func MakeMetadata(r *fasthttp.RequestHeader, method string) (metadata.MD, string) {
-	method = strings.TrimPrefix(method, "/api/")
+func ResolveMethodName(uri string) string {
+	return strings.TrimPrefix(uri, "/api/")
+}
+
+// MethodKey is the method of the request without the query. Limits, metering, cluster, split, mirror
+// and journal matching use it, otherwise every query string would be a distinct method
+func MethodKey(ctx *fasthttp.RequestCtx) string {
+	return ResolveMethodName(string(ctx.Path()))
+}
+
+func MakeMetadata(ctx *fasthttp.RequestCtx, method string) (metadata.MD, string) {
+	method = ResolveMethodName(method)
 	md := metadata.Pairs(utils.ProxyMethodNameHeader, method)
-	r.VisitAll(func(key, v []byte) {
+	ctx.Request.Header.VisitAll(func(key, v []byte) {
 		lowerHeader := strings.ToLower(string(key))
-		if len(v) > 0 && strings.HasPrefix(lowerHeader, "x-") {
+		if len(v) > 0 && strings.HasPrefix(lowerHeader, "x-") && !isReservedMetadataKey(lowerHeader) {
 			md = metadata.Join(md, metadata.Pairs(lowerHeader, string(v)))
 		}
 	})
+
+	addr := realip.FromRequest(ctx)
+	if addr.IP != nil {
+		md.Set(ClientIpHeader, addr.IP.String())
+	}
+	md.Set(ClientSchemeHeader, addr.Scheme)
+	if addr.Host != "" {
+		md.Set(ClientHostHeader, addr.Host)
+	}
+	for key, values := range getRequestMetadata(ctx) {
+		md.Set(key, values...)
+	}
 	return md, method
 }
 
@@ -104,8 +136,15 @@ func SendError(errorMessage string, errorCode codes.Code, details []interface{},
 	_, _ = ctx.Write(msg)
 }
 
-func GetGrpcClient() (isp.BackendServiceClient, error) {
-	return invoker.RouterClient.Conn()
+// GetGrpcClient returns the client of the router cluster chosen by the traffic split or serving the method
+// with the route to take timeouts from, the response is marked with the failover header if calls go to the standby cluster
+func GetGrpcClient(ctx *fasthttp.RequestCtx, method string) (isp.BackendServiceClient, invoker.Route, error) {
+	client, route, err := invoker.Conn(method, canary.Cluster(ctx))
+	if err == nil && route.Failover {
+		cfg := config.GetRemote().(*conf.RemoteConfig)
+		ctx.Response.Header.Set(cfg.RouterFailover.GetHeader(), route.Cluster)
+	}
+	return client, route, err
 }
 
 func convertError(err error) ([]byte, int) {